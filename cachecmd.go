@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"waller/internal/cache"
+	"waller/internal/config"
+	"waller/internal/vfs"
+)
+
+// cacheUsage lists the actions of "waller cache".
+const cacheUsage = `Usage: waller cache <action>
+
+Actions:
+  stats    Show the size of the thumbnail cache
+  prune    Evict thumbnails over the configured size or age limit, and
+           entries extracted from archives that were changed or deleted since
+  clear    Remove all thumbnails and their metadata
+  verify   Remove thumbnails of deleted images and ones that cannot be decoded,
+           and entries extracted from archives that were changed or deleted since`
+
+// runCache implements "waller cache": it inspects and trims the thumbnail cache.
+func runCache(args []string) {
+	if len(args) == 0 {
+		fmt.Println(cacheUsage)
+		os.Exit(2)
+	}
+
+	// The store must be opened in the configured layout, or it would be converted
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("Could not load config", "error", err)
+		os.Exit(1)
+	}
+	cache.UseLayout(cache.ParseLayout(cfg.ThumbnailStore))
+
+	switch args[0] {
+	case "stats":
+		s, err := cache.GetStats()
+		if err != nil {
+			slog.Error("Could not read thumbnail cache", "error", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Thumbnail cache: %s\n", s.Dir)
+		fmt.Printf("  %d thumbnails, %.1f MiB\n", s.Thumbnails, float64(s.Bytes)/(1<<20))
+		fmt.Printf("  %d images with stored colors and hashes\n", s.Entries)
+		if !s.Oldest.IsZero() {
+			fmt.Printf("  least recently used: %s\n", s.Oldest.Format(time.DateTime))
+		}
+
+	case "prune":
+		fs := flag.NewFlagSet("cache prune", flag.ExitOnError)
+		maxMB := fs.Int("max-mb", cfg.ThumbnailCacheMaxMB, "Shrink the cache to at most this many MiB, least recently used first (0 = no limit)")
+		maxDays := fs.Int("max-days", cfg.ThumbnailCacheMaxDays, "Remove thumbnails not shown for this many days (0 = no limit)")
+		fs.Parse(args[1:])
+
+		limits := cache.Limits{MaxSize: int64(*maxMB) << 20, MaxAge: time.Duration(*maxDays) * 24 * time.Hour}
+		if limits == (cache.Limits{}) {
+			fmt.Println("No cache limit configured; set thumbnail_cache_max_mb or thumbnail_cache_max_days, or pass --max-mb or --max-days")
+			pruneArchives()
+			return
+		}
+		r, err := cache.Prune(limits)
+		printRemoval("Evicted", r, err)
+		pruneArchives()
+
+	case "clear":
+		r, err := cache.Clear()
+		printRemoval("Removed", r, err)
+
+	case "verify":
+		orphaned, corrupt, err := cache.Verify()
+		printRemoval("Removed orphaned", orphaned, err)
+		printRemoval("Removed corrupt", corrupt, nil)
+		pruneArchives()
+
+	default:
+		fmt.Printf("Unknown cache action %q\n\n%s\n", args[0], cacheUsage)
+		os.Exit(2)
+	}
+}
+
+// printRemoval reports thumbnails removed by a cache action, or exits on err.
+func printRemoval(what string, r cache.Removal, err error) {
+	if err != nil {
+		slog.Error("Cache maintenance failed", "error", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s %d thumbnails, %.1f MiB freed\n", what, r.Count, float64(r.Bytes)/(1<<20))
+}
+
+// pruneArchives removes entries extracted from stale archives and reports what was freed.
+func pruneArchives() {
+	dirs, size, err := vfs.Prune()
+	if err != nil {
+		slog.Warn("Could not prune extracted archive entries", "error", err)
+		return
+	}
+	if dirs > 0 {
+		fmt.Printf("Removed the extracted entries of %d stale archives, %.1f MiB freed\n", dirs, float64(size)/(1<<20))
+	}
+}
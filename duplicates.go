@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"waller/internal/backend"
+	"waller/internal/cache"
+	"waller/internal/index"
+	"waller/internal/phash"
+)
+
+// runDuplicates implements "waller duplicates": it lists groups of
+// near-identical images across the libraries, largest copy first.
+func runDuplicates(args []string) {
+	fs := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	library := fs.String("library", "", "Only search the named library")
+	threshold := fs.Int("threshold", phash.DefaultThreshold, "Maximum perceptual hash distance (0-64) for two images to count as duplicates")
+	fs.Parse(args)
+
+	cfg, dirs := loadConfigAndDirs(*library)
+	files, err := backend.GetWallpapers(dirs, backend.OptionsFromConfig(cfg))
+	if err != nil {
+		slog.Error("Error scanning wallpapers", "error", err)
+		os.Exit(1)
+	}
+
+	groups := cache.Duplicates(files, *threshold)
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found")
+		return
+	}
+
+	idx, err := index.Open()
+	if err != nil {
+		slog.Error("Could not open metadata index", "error", err)
+		os.Exit(1)
+	}
+	for _, group := range groups {
+		idx.Update(group)
+	}
+	if err := idx.Save(); err != nil {
+		slog.Warn("Failed to save metadata index", "error", err)
+	}
+
+	for i, group := range groups {
+		idx.SortLargestFirst(group)
+		fmt.Printf("Group %d (%d images):\n", i+1, len(group))
+		for _, path := range group {
+			e, _ := idx.Get(path)
+			fmt.Printf("  %dx%d  %7.1f KiB  %s\n", e.Width, e.Height, float64(e.Size)/1024, path)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"waller/internal/cache"
+	"waller/internal/fit"
+	"waller/internal/index"
+	"waller/internal/metadata"
+	"waller/internal/monitor"
+	"waller/internal/order"
+	"waller/internal/palette"
+	"waller/internal/vfs"
+)
+
+// pickFilters are the CLI restrictions on which wallpapers --random and --auto may pick.
+type pickFilters struct {
+	// match keeps wallpapers that suit the monitors monitorIndex refers to.
+	match        bool
+	monitorIndex int
+	// monitors are the connected monitors, read once by readMonitors.
+	monitors []fit.Monitor
+	// color keeps wallpapers dominated by this hue ("" = any).
+	color string
+	// favorites keeps wallpapers marked as favorite.
+	favorites bool
+	// tags keeps wallpapers with at least one of these tags (empty = any).
+	tags []string
+}
+
+// readMonitors reads the monitor geometries the match filter needs. GDK may
+// only be used from the main thread, so this runs once at startup instead of
+// on every rescan.
+func (f *pickFilters) readMonitors() {
+	if f.match {
+		f.monitors = monitor.Geometries()
+	}
+}
+
+// apply returns the files that pass every active filter.
+func (f pickFilters) apply(files []string) ([]string, error) {
+	var err error
+	if f.favorites || len(f.tags) > 0 {
+		if files, err = matchMetadata(files, f.favorites, f.tags); err != nil {
+			return nil, err
+		}
+	}
+	if f.color != "" {
+		files = matchColor(files, f.color)
+	}
+	if f.match {
+		if files, err = matchMonitors(files, f.monitors, f.monitorIndex); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// openIndex opens the metadata index and brings it up to date for files.
+func openIndex(files []string) (*index.Index, error) {
+	idx, err := index.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open metadata index: %w", err)
+	}
+	idx.Update(files)
+	if err := idx.Save(); err != nil {
+		slog.Warn("Failed to save metadata index", "error", err)
+	}
+	return idx, nil
+}
+
+// matchMonitors returns the files that suit the monitors among mons a wallpaper
+// applied to monitorIndex is shown on. Image sizes come from the metadata index.
+func matchMonitors(files []string, mons []fit.Monitor, monitorIndex int) ([]string, error) {
+	targets := monitor.Targets(mons, monitorIndex)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no monitor with index %d", monitorIndex)
+	}
+	idx, err := openIndex(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return fit.Filter(files, func(path string) (int, int, bool) {
+		e, ok := idx.Get(path)
+		return e.Width, e.Height, ok
+	}, targets), nil
+}
+
+// matchColor returns the files whose dominant colors include the named hue.
+// Palettes are read from the thumbnail cache and computed where missing.
+func matchColor(files []string, name string) []string {
+	var matched []string
+	for i, m := range cache.AnalyzeAll(files) {
+		if palette.Matches(m.Palette, name) {
+			matched = append(matched, files[i])
+		}
+	}
+	return matched
+}
+
+// matchMetadata returns the files that are favorites (if favorites is set)
+// and carry at least one of tags (if any are given).
+// Files are looked up by content hash, so annotations follow renamed files.
+func matchMetadata(files []string, favorites bool, tags []string) ([]string, error) {
+	store, err := metadata.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open favorites and tags: %w", err)
+	}
+	idx, err := openIndex(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, path := range files {
+		e, ok := idx.Get(path)
+		if !ok {
+			continue
+		}
+		r := store.Get(e.Hash)
+		if favorites && !r.Favorite {
+			continue
+		}
+		if len(tags) > 0 && !r.HasAnyTag(tags) {
+			continue
+		}
+		matched = append(matched, path)
+	}
+	return matched, nil
+}
+
+// sortWallpapers orders files for a sequential rotation. Resolutions come from
+// the metadata index and hues from the thumbnail cache, computed where missing.
+func sortWallpapers(files []string, o order.Order) error {
+	var idx *index.Index
+	if o.Key == order.Resolution {
+		var err error
+		if idx, err = openIndex(files); err != nil {
+			return err
+		}
+	}
+	hues := make(map[string]float64)
+	if o.Key == order.Hue {
+		for i, m := range cache.AnalyzeAll(files) {
+			hues[files[i]] = palette.SortKey(m.Palette)
+		}
+	}
+
+	order.Sort(files, o, func(path string) order.Info {
+		info := order.Info{Hue: hues[path]}
+		if o.Key == order.Modified || o.Key == order.Size {
+			if fi, err := vfs.Stat(path); err == nil {
+				info.ModTime, info.Size = fi.ModTime(), fi.Size()
+			}
+		}
+		if idx != nil {
+			if e, ok := idx.Get(path); ok {
+				info.Width, info.Height = e.Width, e.Height
+			}
+		}
+		return info
+	})
+	return nil
+}
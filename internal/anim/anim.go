@@ -0,0 +1,296 @@
+// Package anim plays animated GIF and WebP images as wallpapers.
+// The daemon can only show still images, so each animation is decoded once,
+// composited into full frames and written as PNG files to the waller cache
+// directory, together with the per-frame delays.
+package anim
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxFPS caps playback when no frame rate is configured.
+const DefaultMaxFPS = 30
+
+// defaultDelay replaces delays of 10ms or less, which browsers also treat as
+// "unspecified" rather than as a request to play as fast as possible.
+const defaultDelay = 100 * time.Millisecond
+
+// Frame is one rendered frame of an animation.
+type Frame struct {
+	Path  string        `json:"path"`
+	Delay time.Duration `json:"delay"`
+}
+
+// manifestName is written last in a frame directory and marks it complete.
+// Its modification time records when the animation was last shown.
+const manifestName = "frames.json"
+
+// MaxAnimations is how many rendered animations are kept; the least recently
+// shown are removed.
+const MaxAnimations = 8
+
+// abandonedAge is how old a frame directory without a manifest must be before
+// it is treated as left behind by an interrupted render rather than one in progress.
+const abandonedAge = time.Hour
+
+var (
+	dir     string
+	dirOnce sync.Once
+	dirErr  error
+)
+
+// locks serializes rendering of the same animation, which the daemon
+// requests once per monitor when a wallpaper is applied to all of them.
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*sync.Mutex)
+)
+
+func initDir() {
+	dirOnce.Do(func() {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			dirErr = err
+			return
+		}
+		dir = filepath.Join(cacheDir, "waller", "frames")
+		dirErr = os.MkdirAll(dir, 0755)
+	})
+}
+
+// sniff returns "gif" or "webp" from the leading bytes of r, or "" otherwise.
+func sniff(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "gif"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// FrameCount returns the number of frames in an image of the given format
+// ("gif" or "webp") by walking its block structure without decoding pixels.
+// Other formats always have one frame.
+func FrameCount(r io.Reader, format string) (int, error) {
+	switch format {
+	case "gif":
+		return countGIF(r)
+	case "webp":
+		return countWebP(r)
+	}
+	return 1, nil
+}
+
+// IsAnimated reports whether the image at path has more than one frame.
+func IsAnimated(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	format := sniff(header[:n])
+	if format == "" {
+		return false
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	count, err := FrameCount(f, format)
+	return err == nil && count > 1
+}
+
+// Frames returns the rendered frames of the animation at path, rendering them
+// on first use. A frame shown for less than 1/maxFPS seconds is skipped and its
+// time given to the frame after it; maxFPS <= 0 means DefaultMaxFPS.
+func Frames(path string, maxFPS int) ([]Frame, error) {
+	if maxFPS <= 0 {
+		maxFPS = DefaultMaxFPS
+	}
+
+	initDir()
+	if dirErr != nil {
+		return nil, dirErr
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The source's size and mtime are part of the key so edits are picked up
+	sum := md5.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano(), maxFPS)))
+	key := hex.EncodeToString(sum[:])
+	frameDir := filepath.Join(dir, key)
+	manifest := filepath.Join(frameDir, manifestName)
+
+	mu := lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if data, err := os.ReadFile(manifest); err == nil {
+		var frames []Frame
+		if err := json.Unmarshal(data, &frames); err == nil && len(frames) > 0 {
+			now := time.Now()
+			os.Chtimes(manifest, now, now)
+			return frames, nil
+		}
+	}
+
+	// Start from scratch in case an earlier render was interrupted
+	os.RemoveAll(frameDir)
+	if err := os.MkdirAll(frameDir, 0755); err != nil {
+		return nil, err
+	}
+
+	frames, err := render(path, frameDir, time.Second/time.Duration(maxFPS))
+	if err != nil {
+		os.RemoveAll(frameDir)
+		return nil, err
+	}
+
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return nil, err
+	}
+	tmp := manifest + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, manifest); err != nil {
+		return nil, err
+	}
+
+	prune(MaxAnimations)
+	return frames, nil
+}
+
+// prune removes all but the keep most recently shown animations, and frame
+// directories left behind by interrupted renders.
+func prune(keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type animation struct {
+		path string
+		used time.Time
+	}
+	var all []animation
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(path, manifestName)); err == nil {
+			all = append(all, animation{path, info.ModTime()})
+		} else if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > abandonedAge {
+			os.RemoveAll(path)
+		}
+	}
+	if len(all) <= keep {
+		return
+	}
+
+	slices.SortFunc(all, func(a, b animation) int {
+		return b.used.Compare(a.used)
+	})
+	for _, a := range all[keep:] {
+		os.RemoveAll(a.path)
+	}
+}
+
+// render decodes the animation at path and writes its frames into frameDir.
+func render(path, frameDir string, minDelay time.Duration) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	format := sniff(header[:n])
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	emit := func(img image.Image, delay time.Duration) error {
+		if delay <= 10*time.Millisecond {
+			delay = defaultDelay
+		}
+		// The previous frame is too short to show at the frame-rate cap,
+		// so this one takes its place and its time
+		framePath := filepath.Join(frameDir, strconv.Itoa(len(frames))+".png")
+		last := len(frames) - 1
+		replace := last >= 0 && frames[last].Delay < minDelay
+		if replace {
+			framePath = frames[last].Path
+		}
+
+		out, err := os.Create(framePath)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(out, img); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+		if replace {
+			frames[last].Delay += delay
+		} else {
+			frames = append(frames, Frame{Path: framePath, Delay: delay})
+		}
+		return nil
+	}
+
+	switch format {
+	case "gif":
+		err = decodeGIF(f, emit)
+	case "webp":
+		err = decodeWebP(f, emit)
+	default:
+		err = fmt.Errorf("%s: not an animated GIF or WebP", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("%s: no frames", path)
+	}
+	return frames, nil
+}
+
+// lock returns the mutex guarding rendering for key.
+func lock(key string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	mu, ok := locks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[key] = mu
+	}
+	return mu
+}
@@ -0,0 +1,269 @@
+package anim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeGIF encodes an animation of solid frames, each covering the given rect.
+func writeGIF(t *testing.T, path string, rects []image.Rectangle, colors []color.Color, delays []int) {
+	t.Helper()
+	palette := color.Palette{color.Transparent, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}}
+	g := &gif.GIF{Config: image.Config{Width: 4, Height: 4, ColorModel: palette}}
+	for i, rect := range rects {
+		frame := image.NewPaletted(rect, palette)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				frame.Set(x, y, colors[i])
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, delays[i])
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// pixelAt decodes the PNG frame at path and returns the color at (x, y).
+func pixelAt(t *testing.T, path string, x, y int) color.RGBA {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	r, g, b, a := img.At(x, y).RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+}
+
+// TestGIFFrames verifies frame counting, compositing and the frame-rate cap.
+func TestGIFFrames(t *testing.T) {
+	// Arrange: a red background, a 20ms blue square top left, then a blue square
+	// bottom right; the 20ms frame is too short for a 20 fps cap
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "anim.gif")
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	writeGIF(t, path,
+		[]image.Rectangle{image.Rect(0, 0, 4, 4), image.Rect(0, 0, 2, 2), image.Rect(2, 2, 4, 4)},
+		[]color.Color{red, blue, blue},
+		[]int{50, 2, 20},
+	)
+
+	// Act
+	animated := IsAnimated(path)
+	frames, err := Frames(path, 20)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !animated {
+		t.Errorf("Expected the GIF to be reported as animated")
+	}
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames after capping, got %d", len(frames))
+	}
+	if frames[0].Delay != 500*time.Millisecond || frames[1].Delay != 220*time.Millisecond {
+		t.Errorf("Expected delays 500ms and 220ms, got %v and %v", frames[0].Delay, frames[1].Delay)
+	}
+	if got := pixelAt(t, frames[0].Path, 0, 0); got != red {
+		t.Errorf("Expected red at (0,0) in frame 0, got %v", got)
+	}
+	if got := pixelAt(t, frames[1].Path, 0, 0); got != blue {
+		t.Errorf("Expected blue at (0,0) in frame 1, got %v", got)
+	}
+	if got := pixelAt(t, frames[1].Path, 3, 3); got != blue {
+		t.Errorf("Expected blue at (3,3) in frame 1, got %v", got)
+	}
+	if got := pixelAt(t, frames[1].Path, 3, 0); got != red {
+		t.Errorf("Expected the red background at (3,0) in frame 1, got %v", got)
+	}
+}
+
+// bitWriter writes values LSB first, as the VP8L bitstream expects.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((v>>i)&1) << (w.nbits % 8)
+		w.nbits++
+	}
+}
+
+// solidVP8L returns a lossless VP8L chunk of a w×h image filled with c. Each
+// prefix code has a single symbol, so the pixels themselves take no bits.
+func solidVP8L(w, h int, c color.NRGBA) []byte {
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(1, 1) // Alpha is used
+	bw.write(0, 3) // Version
+	bw.write(0, 1) // No transforms
+	bw.write(0, 1) // No color cache
+	bw.write(0, 1) // No meta prefix codes
+	for _, symbol := range []uint8{c.G, c.R, c.B, c.A, 0} {
+		bw.write(1, 1) // Simple code
+		bw.write(0, 1) // One symbol
+		bw.write(1, 1) // 8-bit symbol
+		bw.write(uint32(symbol), 8)
+	}
+	return riffChunk("VP8L", bw.buf)
+}
+
+// riffChunk encodes a RIFF chunk with its padding byte.
+func riffChunk(id string, data []byte) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// anmf encodes an ANMF chunk for a frame at (x, y) shown for delay milliseconds.
+func anmf(x, y, w, h, delay int, flags byte, frame []byte) []byte {
+	header := make([]byte, 16)
+	putUint24(header[0:], x/2)
+	putUint24(header[3:], y/2)
+	putUint24(header[6:], w-1)
+	putUint24(header[9:], h-1)
+	putUint24(header[12:], delay)
+	header[15] = flags
+	return riffChunk("ANMF", append(header, frame...))
+}
+
+// TestWebPFrames verifies parsing of animated WebP files.
+func TestWebPFrames(t *testing.T) {
+	// Arrange: a 4×4 red frame, then a 2×2 green frame at (2,2) disposed afterwards,
+	// then a 2×2 blue frame at (0,0)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = flagAnimation | flagAlpha
+	putUint24(vp8x[4:], 3)
+	putUint24(vp8x[7:], 3)
+	body := []byte("WEBP")
+	body = append(body, riffChunk("VP8X", vp8x)...)
+	body = append(body, riffChunk("ANIM", make([]byte, 6))...)
+	body = append(body, anmf(0, 0, 4, 4, 300, 0, solidVP8L(4, 4, red))...)
+	body = append(body, anmf(2, 2, 2, 2, 100, 0x01, solidVP8L(2, 2, green))...)
+	body = append(body, anmf(0, 0, 2, 2, 100, 0, solidVP8L(2, 2, blue))...)
+	data := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+	data = append(data, body...)
+
+	path := filepath.Join(t.TempDir(), "anim.webp")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	count, countErr := FrameCount(bytes.NewReader(data), "webp")
+	frames, err := Frames(path, 0)
+
+	// Assert
+	if countErr != nil || count != 3 {
+		t.Errorf("Expected 3 frames, got %d (%v)", count, countErr)
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(frames))
+	}
+	if frames[0].Delay != 300*time.Millisecond {
+		t.Errorf("Expected 300ms for frame 0, got %v", frames[0].Delay)
+	}
+	if got := pixelAt(t, frames[1].Path, 3, 3); got != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("Expected green at (3,3) in frame 1, got %v", got)
+	}
+	if got := pixelAt(t, frames[2].Path, 3, 3); got != (color.RGBA{}) {
+		t.Errorf("Expected the disposed area to be cleared in frame 2, got %v", got)
+	}
+	if got := pixelAt(t, frames[2].Path, 0, 0); got != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("Expected blue at (0,0) in frame 2, got %v", got)
+	}
+	if got := pixelAt(t, frames[2].Path, 3, 0); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Expected red at (3,0) in frame 2, got %v", got)
+	}
+}
+
+// TestStillImagesAreNotAnimated verifies single-frame files are not reported as animated.
+func TestStillImagesAreNotAnimated(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "still.gif")
+	writeGIF(t, path, []image.Rectangle{image.Rect(0, 0, 4, 4)}, []color.Color{color.RGBA{255, 0, 0, 255}}, []int{0})
+
+	// Act
+	animated := IsAnimated(path)
+
+	// Assert
+	if animated {
+		t.Errorf("Expected a single-frame GIF not to be animated")
+	}
+}
+
+// TestPrune verifies that only the most recently shown animations are kept and
+// that interrupted renders are cleaned up once they are old.
+func TestPrune(t *testing.T) {
+	// Arrange
+	initDir()
+	saved := dir
+	dir = t.TempDir()
+	t.Cleanup(func() { dir = saved })
+	long := time.Now().Add(-2 * abandonedAge)
+	for i, name := range []string{"old", "recent", "newest"} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+		manifest := filepath.Join(dir, name, manifestName)
+		os.WriteFile(manifest, []byte("[]"), 0644)
+		used := long.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(manifest, used, used)
+	}
+	os.Mkdir(filepath.Join(dir, "interrupted"), 0755)
+	os.Chtimes(filepath.Join(dir, "interrupted"), long, long)
+	os.Mkdir(filepath.Join(dir, "rendering"), 0755)
+
+	// Act
+	prune(2)
+
+	// Assert
+	entries, _ := os.ReadDir(dir)
+	var kept []string
+	for _, entry := range entries {
+		kept = append(kept, entry.Name())
+	}
+	if want := []string{"newest", "recent", "rendering"}; !slices.Equal(kept, want) {
+		t.Errorf("Expected %v to be kept, got %v", want, kept)
+	}
+}
@@ -0,0 +1,126 @@
+package anim
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// errBadGIF is returned when the GIF block structure cannot be parsed.
+var errBadGIF = errors.New("malformed GIF")
+
+// countGIF counts image descriptors in a GIF stream.
+func countGIF(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+
+	// Header and logical screen descriptor
+	var screen [13]byte
+	if _, err := io.ReadFull(br, screen[:]); err != nil {
+		return 0, err
+	}
+	if err := skipColorTable(br, screen[10]); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		block, err := br.ReadByte()
+		if err != nil {
+			return count, err
+		}
+		switch block {
+		case 0x21: // Extension: label, then data sub-blocks
+			if _, err := br.ReadByte(); err != nil {
+				return count, err
+			}
+			if err := skipSubBlocks(br); err != nil {
+				return count, err
+			}
+		case 0x2C: // Image descriptor
+			count++
+			var desc [9]byte
+			if _, err := io.ReadFull(br, desc[:]); err != nil {
+				return count, err
+			}
+			if err := skipColorTable(br, desc[8]); err != nil {
+				return count, err
+			}
+			if _, err := br.ReadByte(); err != nil { // LZW minimum code size
+				return count, err
+			}
+			if err := skipSubBlocks(br); err != nil {
+				return count, err
+			}
+		case 0x3B: // Trailer
+			return count, nil
+		default:
+			return count, errBadGIF
+		}
+	}
+}
+
+// skipColorTable skips the color table announced by the packed fields byte.
+func skipColorTable(br *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+	_, err := br.Discard(3 << ((packed & 0x07) + 1))
+	return err
+}
+
+// skipSubBlocks skips a sequence of length-prefixed sub-blocks.
+func skipSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeGIF composites the frames of a GIF and passes each to emit.
+// The image passed to emit is only valid until emit returns.
+func decodeGIF(r io.Reader, emit func(image.Image, time.Duration) error) error {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		bounds := frame.Bounds()
+		draw.Draw(canvas, bounds, frame, bounds.Min, draw.Over)
+		if err := emit(canvas, time.Duration(g.Delay[i])*10*time.Millisecond); err != nil {
+			return err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, bounds, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package anim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// errBadWebP is returned when the RIFF container of a WebP file cannot be parsed.
+var errBadWebP = errors.New("malformed WebP")
+
+// VP8X feature flags.
+const (
+	flagAnimation = 0x02
+	flagAlpha     = 0x10
+)
+
+// chunk is one RIFF chunk of a WebP file.
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// readChunks calls fn for each top-level chunk of a WebP stream. Only chunks
+// that fn asks for by id are read into memory; the rest are skipped.
+func readChunks(r io.Reader, want func(id string) bool, fn func(chunk) error) error {
+	br := bufio.NewReader(r)
+
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return errBadWebP
+	}
+
+	for {
+		var head [8]byte
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		id := string(head[:4])
+		size := int(binary.LittleEndian.Uint32(head[4:]))
+		padded := size + size&1
+
+		if !want(id) {
+			if _, err := br.Discard(padded); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data := make([]byte, padded)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		if err := fn(chunk{id: id, data: data[:size]}); err != nil {
+			return err
+		}
+	}
+}
+
+// uint24 decodes a little-endian 24-bit integer.
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// countWebP returns the number of ANMF frames of an animated WebP, or 1 for a still image.
+func countWebP(r io.Reader) (int, error) {
+	animated := false
+	count := 0
+	// Frames are counted from their chunk headers; only VP8X is read
+	want := func(id string) bool {
+		if id == "ANMF" {
+			count++
+		}
+		return id == "VP8X"
+	}
+	err := readChunks(r, want, func(c chunk) error {
+		if len(c.data) < 1 {
+			return errBadWebP
+		}
+		animated = c.data[0]&flagAnimation != 0
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !animated || count == 0 {
+		return 1, nil
+	}
+	return count, nil
+}
+
+// decodeWebP composites the frames of an animated WebP and passes each to emit.
+// A still WebP yields a single frame.
+func decodeWebP(r io.ReadSeeker, emit func(image.Image, time.Duration) error) error {
+	var canvas *image.RGBA
+	animated := false
+
+	err := readChunks(r, func(id string) bool { return id == "VP8X" || id == "ANMF" }, func(c chunk) error {
+		switch c.id {
+		case "VP8X":
+			if len(c.data) < 10 {
+				return errBadWebP
+			}
+			animated = c.data[0]&flagAnimation != 0
+			canvas = image.NewRGBA(image.Rect(0, 0, 1+uint24(c.data[4:7]), 1+uint24(c.data[7:10])))
+		case "ANMF":
+			if canvas == nil || len(c.data) < 16 {
+				return errBadWebP
+			}
+			return decodeFrame(canvas, c.data, emit)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !animated {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		img, err := webp.Decode(r)
+		if err != nil {
+			return err
+		}
+		return emit(img, 0)
+	}
+	return nil
+}
+
+// decodeFrame draws one ANMF frame onto canvas, emits the result and applies
+// the frame's disposal method.
+func decodeFrame(canvas *image.RGBA, data []byte, emit func(image.Image, time.Duration) error) error {
+	x, y := 2*uint24(data[0:3]), 2*uint24(data[3:6])
+	w, h := 1+uint24(data[6:9]), 1+uint24(data[9:12])
+	delay := time.Duration(uint24(data[12:15])) * time.Millisecond
+	blend := data[15]&0x02 == 0
+	dispose := data[15]&0x01 != 0
+
+	img, err := webp.Decode(bytes.NewReader(stillWebP(data[16:], w, h)))
+	if err != nil {
+		return err
+	}
+
+	rect := image.Rect(x, y, x+w, y+h)
+	op := draw.Over
+	if !blend {
+		op = draw.Src
+	}
+	draw.Draw(canvas, rect, img, img.Bounds().Min, op)
+
+	if err := emit(canvas, delay); err != nil {
+		return err
+	}
+	if dispose {
+		draw.Draw(canvas, rect, image.Transparent, image.Point{}, draw.Src)
+	}
+	return nil
+}
+
+// stillWebP wraps the chunks of an ANMF frame into a standalone WebP file.
+// Frames with an ALPH chunk need a VP8X header announcing the alpha channel.
+func stillWebP(frame []byte, w, h int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if bytes.HasPrefix(frame, []byte("ALPH")) {
+		var vp8x [18]byte
+		copy(vp8x[:], "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = flagAlpha
+		putUint24(vp8x[12:], w-1)
+		putUint24(vp8x[15:], h-1)
+		body.Write(vp8x[:])
+	}
+	body.Write(frame)
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// putUint24 encodes v as a little-endian 24-bit integer.
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
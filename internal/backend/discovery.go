@@ -1,11 +1,17 @@
 // Package backend handles wallpaper file discovery and scanning.
-// It finds all supported image files in a given directory.
+// It finds all supported image files in one or more directory trees,
+// including images inside zip and tar archives, which are treated as folders.
 package backend
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"waller/internal/config"
+	"waller/internal/vfs"
 )
 
 // validExtensions is a set (map for O(1) lookup) of supported image file types.
@@ -14,29 +20,271 @@ var validExtensions = map[string]bool{
 	".jpeg": true,
 	".png":  true,
 	".webp": true,
+	".gif":  true,
+	".bmp":  true,
+	".tif":  true,
+	".tiff": true,
+	".avif": true, // AVIF and JPEG XL are converted before display
+	".jxl":  true,
+}
+
+// Options controls how directory trees are scanned.
+type Options struct {
+	// MaxDepth limits how many directory levels are scanned, counting the
+	// root directory as level 1. Zero means no limit.
+	MaxDepth int
+	// FollowSymlinks descends into symlinked directories.
+	// Symlinks to files are always included.
+	FollowSymlinks bool
+	// Verify sniffs each candidate's content instead of trusting its extension
+	// and skips files that are not real images or are truncated.
+	Verify bool
+	// Include, if set, keeps only files matching one of these rules.
+	// Exclude drops files and directories matching one of these rules.
+	// Rules are globs ("*_thumb.jpg", "drafts/**") or, prefixed with "re:",
+	// regular expressions; both match the path relative to the library root,
+	// and globs without a slash match the file name alone.
+	Include []string
+	Exclude []string
+	// ShowHidden includes files and directories whose names start with a dot.
+	ShowHidden bool
+}
+
+// OptionsFromConfig returns the scan options set in cfg.
+func OptionsFromConfig(cfg *config.Config) Options {
+	return Options{
+		MaxDepth:       cfg.MaxDepth,
+		FollowSymlinks: cfg.FollowSymlinks,
+		Verify:         !cfg.SkipVerify,
+		Include:        cfg.Include,
+		Exclude:        cfg.Exclude,
+		ShowHidden:     cfg.ShowHidden,
+	}
 }
 
-// GetWallpapers scans the given directory and returns a list of absolute paths
-// for all supported image files found.
-func GetWallpapers(dir string) ([]string, error) {
+// Skipped records a file or directory left out of a scan and why.
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+// Result is the outcome of a scan.
+type Result struct {
+	Wallpapers []string
+	Skipped    []Skipped
+}
+
+// dirID identifies a directory by device and inode so that symlink loops
+// and directories reachable through several links are only scanned once.
+type dirID struct {
+	dev uint64
+	ino uint64
+}
+
+// scanner holds the state of a single Scan call.
+type scanner struct {
+	opts    Options
+	include []pattern
+	exclude []pattern
+	root    string // Library root of the directory being walked
+	visited map[dirID]bool
+	result  Result
+}
+
+// GetWallpapers scans the given directories recursively and returns a merged
+// list of absolute paths for all supported image files found.
+func GetWallpapers(dirs []string, opts Options) ([]string, error) {
+	result, err := Scan(dirs, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Wallpapers, nil
+}
+
+// Scan walks the given directories and returns the wallpapers found together
+// with the files that were skipped.
+// Directories shared between several roots are only listed once.
+// An unreadable root is logged and skipped; an error is returned only when
+// none of the roots could be read.
+func Scan(dirs []string, opts Options) (*Result, error) {
+	include, err := compilePatterns(opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compilePatterns(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &scanner{
+		opts:    opts,
+		include: include,
+		exclude: exclude,
+		visited: make(map[dirID]bool),
+	}
+
+	var firstErr error
+	scanned := 0
+	for _, dir := range dirs {
+		s.root = dir
+		if err := s.walk(dir, 1, nil); err != nil {
+			slog.Warn("Skipping unreadable wallpaper directory", "dir", dir, "error", err)
+			s.skip(dir, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		scanned++
+	}
+
+	if scanned == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	return &s.result, nil
+}
+
+// excluded reports whether path is left out by ignore files or the
+// include/exclude rules. Include rules only apply to files.
+func (s *scanner) excluded(path string, isDir bool, rules []ignoreRule) bool {
+	if ignored(rules, path, isDir) {
+		return true
+	}
+	if len(s.include) == 0 && len(s.exclude) == 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	if matchAny(s.exclude, rel) {
+		return true
+	}
+	return !isDir && len(s.include) > 0 && !matchAny(s.include, rel)
+}
+
+// skip records a path that was left out of the scan.
+func (s *scanner) skip(path string, err error) {
+	s.result.Skipped = append(s.result.Skipped, Skipped{Path: path, Reason: err.Error()})
+}
+
+// walk scans dir, which sits at the given depth, and descends into its subdirectories.
+// rules are the ignore rules inherited from parent directories.
+func (s *scanner) walk(dir string, depth int, rules []ignoreRule) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		id := dirID{dev: uint64(st.Dev), ino: st.Ino}
+		if s.visited[id] {
+			slog.Debug("Skipping already scanned directory", "dir", dir)
+			return nil
+		}
+		s.visited[id] = true
+	}
+
 	// ReadDir reads the named directory and returns all its directory entries sorted by filename.
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	wallpapers := make([]string, 0, len(entries))
+	// Clip capacity so sibling directories never share appended rules
+	rules = append(rules[:len(rules):len(rules)], readIgnoreFile(dir)...)
 
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if !s.opts.ShowHidden && strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Resolve the link target; dangling links are ignored.
+			target, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			isDir = target.IsDir()
+			if isDir && !s.opts.FollowSymlinks {
+				continue
+			}
+		}
+
+		if s.excluded(path, isDir, rules) {
+			continue
+		}
+
+		if isDir {
+			if s.opts.MaxDepth > 0 && depth >= s.opts.MaxDepth {
+				continue
+			}
+			if err := s.walk(path, depth+1, rules); err != nil {
+				slog.Warn("Skipping unreadable directory", "dir", path, "error", err)
+				s.skip(path, err)
+			}
 			continue
 		}
 
-		ext := strings.ToLower(filepath.Ext(entry.Name()))
-		if validExtensions[ext] {
-			wallpapers = append(wallpapers, filepath.Join(dir, entry.Name()))
+		if vfs.IsArchive(path) {
+			s.scanArchive(path, rules)
+			continue
+		}
+		s.addFile(path)
+	}
+
+	return nil
+}
+
+// scanArchive adds the images inside an archive as virtual paths,
+// treating the archive like a folder.
+func (s *scanner) scanArchive(path string, rules []ignoreRule) {
+	names, err := vfs.List(path)
+	if err != nil {
+		slog.Warn("Skipping unreadable archive", "path", path, "error", err)
+		s.skip(path, err)
+		return
+	}
+
+	for _, name := range names {
+		if !s.opts.ShowHidden && hiddenEntry(name) {
+			continue
+		}
+		virtual := vfs.Join(path, name)
+		if s.excluded(virtual, false, rules) {
+			continue
+		}
+		s.addFile(virtual)
+	}
+}
+
+// hiddenEntry reports whether an archive entry lies in a hidden folder or is
+// hidden itself, including the resource forks macOS adds to zip files.
+func hiddenEntry(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
 		}
 	}
+	return false
+}
 
-	return wallpapers, nil
+// addFile adds path to the result if it is a supported, valid image.
+func (s *scanner) addFile(path string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if !validExtensions[ext] {
+		return
+	}
+	if s.opts.Verify {
+		if _, err := VerifyImage(path); err != nil {
+			s.skip(path, err)
+			return
+		}
+	}
+	s.result.Wallpapers = append(s.result.Wallpapers, path)
 }
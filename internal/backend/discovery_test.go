@@ -1,11 +1,30 @@
 package backend
 
 import (
+	"archive/zip"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+
+	"waller/internal/vfs"
 )
 
+// writeFiles creates empty-content dummy files relative to root, creating parent dirs as needed.
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		fPath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(fPath), 0755); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		if err := os.WriteFile(fPath, []byte("fake content"), 0644); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+}
+
 // TestGetWallpapers verifies finding image files in a directory.
 func TestGetWallpapers(t *testing.T) {
 	// Arrange: Setup a dummy directory with specific files
@@ -26,7 +45,7 @@ func TestGetWallpapers(t *testing.T) {
 	os.WriteFile(nonImage, []byte("ignore me"), 0644)
 
 	// Act
-	images, err := GetWallpapers(tmpDir)
+	images, err := GetWallpapers([]string{tmpDir}, Options{})
 
 	// Assert
 	if err != nil {
@@ -37,3 +56,135 @@ func TestGetWallpapers(t *testing.T) {
 		t.Errorf("Expected 3 images, found %d", len(images))
 	}
 }
+
+// TestGetWallpapersRecursive verifies that subdirectories are scanned up to MaxDepth.
+func TestGetWallpapersRecursive(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	writeFiles(t, tmpDir, "top.jpg", "nature/a.jpg", "nature/forest/b.png", "4k/c.webp")
+
+	tests := []struct {
+		maxDepth int
+		want     int
+	}{
+		{maxDepth: 0, want: 4},
+		{maxDepth: 1, want: 1},
+		{maxDepth: 2, want: 3},
+		{maxDepth: 3, want: 4},
+	}
+
+	for _, tt := range tests {
+		// Act
+		images, err := GetWallpapers([]string{tmpDir}, Options{MaxDepth: tt.maxDepth})
+
+		// Assert
+		if err != nil {
+			t.Fatalf("MaxDepth %d: expected no error, got %v", tt.maxDepth, err)
+		}
+		if len(images) != tt.want {
+			t.Errorf("MaxDepth %d: expected %d images, found %d", tt.maxDepth, tt.want, len(images))
+		}
+	}
+}
+
+// TestGetWallpapersSymlinks verifies symlinked directories are only followed on
+// request and that symlink loops terminate.
+func TestGetWallpapersSymlinks(t *testing.T) {
+	// Arrange: root/sub/loop points back at root, root/linked points at an outside dir
+	tmpDir := t.TempDir()
+	root := filepath.Join(tmpDir, "root")
+	outside := filepath.Join(tmpDir, "outside")
+	writeFiles(t, root, "a.jpg", "sub/b.jpg")
+	writeFiles(t, outside, "c.jpg")
+
+	if err := os.Symlink(root, filepath.Join(root, "sub", "loop")); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linked")); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	plain, err := GetWallpapers([]string{root}, Options{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	followed, err := GetWallpapers([]string{root}, Options{FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	if len(plain) != 2 {
+		t.Errorf("Expected 2 images without following symlinks, found %d", len(plain))
+	}
+	if len(followed) != 3 {
+		t.Errorf("Expected 3 images when following symlinks, found %d: %v", len(followed), followed)
+	}
+}
+
+// TestGetWallpapersMultipleDirs verifies that several libraries are merged without duplicates.
+func TestGetWallpapersMultipleDirs(t *testing.T) {
+	// Arrange: two libraries, one nested inside the other, plus a missing one
+	tmpDir := t.TempDir()
+	synced := filepath.Join(tmpDir, "synced")
+	local := filepath.Join(tmpDir, "local")
+	writeFiles(t, synced, "a.jpg", "nested/b.jpg")
+	writeFiles(t, local, "c.png")
+
+	dirs := []string{synced, local, filepath.Join(synced, "nested"), filepath.Join(tmpDir, "nas")}
+
+	// Act
+	images, err := GetWallpapers(dirs, Options{})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(images) != 3 {
+		t.Errorf("Expected 3 images, found %d: %v", len(images), images)
+	}
+
+	if _, err := GetWallpapers([]string{filepath.Join(tmpDir, "nas")}, Options{}); err == nil {
+		t.Errorf("Expected an error when no directory is readable")
+	}
+}
+
+// TestGetWallpapersArchives verifies images inside archives are listed as virtual paths.
+func TestGetWallpapersArchives(t *testing.T) {
+	// Arrange
+	root := t.TempDir()
+	writeFiles(t, root, "loose.jpg")
+	f, err := os.Create(filepath.Join(root, "pack.zip"))
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, name := range []string{"a.jpg", "sub/b.png", "notes.txt", "__MACOSX/._a.jpg", "c_thumb.jpg"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		w.Write([]byte("fake content"))
+	}
+	zw.Close()
+	f.Close()
+
+	// Act
+	images, err := GetWallpapers([]string{root}, Options{Exclude: []string{"*_thumb.jpg"}})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []string{
+		filepath.Join(root, "loose.jpg"),
+		vfs.Join(filepath.Join(root, "pack.zip"), "a.jpg"),
+		vfs.Join(filepath.Join(root, "pack.zip"), "sub/b.png"),
+	}
+	sort.Strings(images)
+	sort.Strings(want)
+	if strings.Join(images, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, images)
+	}
+}
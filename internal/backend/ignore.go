@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of per-directory files listing paths to leave out,
+// in gitignore syntax. Rules apply to the directory holding the file and below it.
+const IgnoreFile = ".wallerignore"
+
+// pattern matches slash-separated paths relative to a base directory.
+type pattern struct {
+	re *regexp.Regexp
+	// nameOnly patterns contain no slash and match the last path element at any depth.
+	nameOnly bool
+}
+
+// match reports whether rel (relative, slash-separated) matches the pattern.
+func (p pattern) match(rel string) bool {
+	if p.nameOnly {
+		return p.re.MatchString(rel[strings.LastIndex(rel, "/")+1:])
+	}
+	return p.re.MatchString(rel)
+}
+
+// compileGlob turns a gitignore-style glob into a pattern.
+// "*" and "?" stay within one path element, "**" spans any number of them,
+// and a leading "/" anchors the pattern to the base directory.
+func compileGlob(glob string) (pattern, error) {
+	nameOnly := !strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				return pattern{}, fmt.Errorf("unterminated [ in pattern %q", glob)
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return pattern{}, fmt.Errorf("invalid pattern %q: %w", glob, err)
+	}
+	return pattern{re: re, nameOnly: nameOnly}, nil
+}
+
+// compilePatterns compiles include/exclude rules from the config.
+// Rules prefixed with "re:" are regular expressions matched against the
+// path relative to the library root; all others are globs.
+func compilePatterns(rules []string) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(rules))
+	for _, rule := range rules {
+		if expr, ok := strings.CutPrefix(rule, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", rule, err)
+			}
+			patterns = append(patterns, pattern{re: re})
+			continue
+		}
+		p, err := compileGlob(rule)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// matchAny reports whether rel matches one of patterns.
+func matchAny(patterns []pattern, rel string) bool {
+	for _, p := range patterns {
+		if p.match(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoreRule is one line of an ignore file.
+type ignoreRule struct {
+	base    string // Directory holding the ignore file
+	pattern pattern
+	negate  bool
+	dirOnly bool
+}
+
+// readIgnoreFile parses the ignore file in dir, if there is one.
+// Invalid lines are skipped so one typo does not hide a whole library.
+func readIgnoreFile(dir string) []ignoreRule {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// Escaped leading characters lose their special meaning
+		line = strings.TrimPrefix(line, "\\")
+
+		p, err := compileGlob(line)
+		if err != nil {
+			continue
+		}
+		rule.pattern = p
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored reports whether path is excluded by rules. As in gitignore,
+// the last matching rule decides, and "!" rules re-include paths.
+func ignored(rules []ignoreRule, path string, isDir bool) bool {
+	result := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if rule.pattern.match(filepath.ToSlash(rel)) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// relPaths returns paths relative to root, slash-separated and sorted.
+func relPaths(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+	rel := make([]string, 0, len(paths))
+	for _, p := range paths {
+		r, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatalf("Rel failed: %v", err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	sort.Strings(rel)
+	return rel
+}
+
+// TestCompileGlob verifies gitignore-style glob matching.
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"*.png", "a.png", true},
+		{"*.png", "deep/dir/a.png", true},
+		{"*.png", "a.jpg", false},
+		{"/top.jpg", "top.jpg", true},
+		{"/top.jpg", "sub/top.jpg", false},
+		{"drafts/*.jpg", "drafts/a.jpg", true},
+		{"drafts/*.jpg", "drafts/x/a.jpg", false},
+		{"**/raw/*", "a/b/raw/c.jpg", true},
+		{"**/raw/*", "raw/c.jpg", true},
+		{"old/**", "old/a/b.jpg", true},
+		{"a/**/b.jpg", "a/b.jpg", true},
+		{"a/**/b.jpg", "a/x/y/b.jpg", true},
+		{"img?.jpg", "img1.jpg", true},
+		{"img[0-3].jpg", "img4.jpg", false},
+		{"img[!0-3].jpg", "img4.jpg", true},
+	}
+
+	for _, tt := range tests {
+		// Arrange
+		p, err := compileGlob(tt.glob)
+		if err != nil {
+			t.Fatalf("compileGlob(%q) failed: %v", tt.glob, err)
+		}
+
+		// Act
+		got := p.match(tt.path)
+
+		// Assert
+		if got != tt.want {
+			t.Errorf("%q matching %q: expected %v, got %v", tt.glob, tt.path, tt.want, got)
+		}
+	}
+}
+
+// TestScanIgnoreFile verifies .wallerignore files with negation, dir-only
+// rules and inheritance into subdirectories.
+func TestScanIgnoreFile(t *testing.T) {
+	// Arrange
+	root := t.TempDir()
+	writeFiles(t, root,
+		"a.jpg", "a_thumb.jpg", "keep_thumb.jpg",
+		"private/b.jpg",
+		"sub/c.jpg", "sub/c_thumb.jpg", "sub/d.png",
+		"sub/private", // a file, so the dir-only rule must not match it
+	)
+	ignore := "# thumbnails made by other tools\n*_thumb.jpg\n!keep_thumb.jpg\nprivate/\n"
+	if err := os.WriteFile(filepath.Join(root, IgnoreFile), []byte(ignore), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", IgnoreFile), []byte("/*.png\n"), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	images, err := GetWallpapers([]string{root}, Options{})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := strings.Join(relPaths(t, root, images), ",")
+	if want := "a.jpg,keep_thumb.jpg,sub/c.jpg"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// TestScanIncludeExclude verifies config rules and hidden-file handling.
+func TestScanIncludeExclude(t *testing.T) {
+	// Arrange
+	root := t.TempDir()
+	writeFiles(t, root,
+		"4k/a.jpg", "4k/b.png", "phone/c.jpg", "d.jpg",
+		".hidden.jpg", ".stash/e.jpg",
+	)
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"defaults", Options{}, "4k/a.jpg,4k/b.png,d.jpg,phone/c.jpg"},
+		{"show hidden", Options{ShowHidden: true}, ".hidden.jpg,.stash/e.jpg,4k/a.jpg,4k/b.png,d.jpg,phone/c.jpg"},
+		{"exclude dir", Options{Exclude: []string{"phone"}}, "4k/a.jpg,4k/b.png,d.jpg"},
+		{"include glob", Options{Include: []string{"4k/**"}}, "4k/a.jpg,4k/b.png"},
+		{"include regex", Options{Include: []string{`re:\.png$`}}, "4k/b.png"},
+		{"both", Options{Include: []string{"*.jpg"}, Exclude: []string{"re:^4k/"}}, "d.jpg,phone/c.jpg"},
+	}
+
+	for _, tt := range tests {
+		// Act
+		images, err := GetWallpapers([]string{root}, tt.opts)
+
+		// Assert
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tt.name, err)
+		}
+		if got := strings.Join(relPaths(t, root, images), ","); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := GetWallpapers([]string{root}, Options{Exclude: []string{"re:("}}); err == nil {
+		t.Errorf("Expected an error for an invalid regular expression")
+	}
+}
@@ -0,0 +1,282 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"waller/internal/vfs"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Reasons a candidate file fails verification.
+var (
+	ErrUnknownFormat = errors.New("not a supported image format")
+	ErrTruncated     = errors.New("truncated image data")
+)
+
+// verdict is the outcome of verifying a file or archive entry while the file,
+// or the archive holding the entry, had the given size and modification time.
+type verdict struct {
+	size    int64
+	modTime time.Time
+	format  string
+	err     error
+}
+
+// verdicts remembers verdicts by path. Every change the watcher reports
+// rescans the library, and most files are unchanged since the last scan;
+// checking an archive entry even means decompressing all of it.
+var (
+	verdictsMu sync.Mutex
+	verdicts   = make(map[string]verdict)
+)
+
+// trailerSize is how many bytes are read at a time while searching backwards
+// from the end of a file for its end marker.
+const trailerSize = 4096
+
+// detectFormat identifies the image format from the leading magic bytes.
+// It returns "" when the header does not belong to a supported format.
+func detectFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return "webp"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(header, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tiff"
+	case len(header) >= 12 && string(header[4:8]) == "ftyp" && (string(header[8:12]) == "avif" || string(header[8:12]) == "avis"):
+		return "avif"
+	case bytes.HasPrefix(header, []byte{0xFF, 0x0A}), bytes.HasPrefix(header, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return "jxl"
+	}
+	return ""
+}
+
+// VerifyImage checks that the file or archive entry at path really is a supported
+// image, regardless of its extension, and that it is not obviously truncated.
+// It returns the detected format name ("jpeg", "png", "webp", "gif", "bmp",
+// "tiff", "avif" or "jxl"). AVIF and JPEG XL have no Go decoder, so only
+// their signature is checked.
+// The verdict of an earlier call is reused while the file is unchanged.
+func VerifyImage(path string) (string, error) {
+	archive, _, virtual := vfs.Split(path)
+	if !virtual {
+		archive = path
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return "", err
+	}
+	verdictsMu.Lock()
+	v, ok := verdicts[path]
+	verdictsMu.Unlock()
+	if ok && v.size == info.Size() && v.modTime.Equal(info.ModTime()) {
+		return v.format, v.err
+	}
+
+	var format string
+	if virtual {
+		format, err = verifyEntry(path)
+	} else {
+		format, err = verifyFile(path)
+	}
+	// A file that could not be opened or read may be readable next time
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		verdictsMu.Lock()
+		verdicts[path] = verdict{size: info.Size(), modTime: info.ModTime(), format: format, err: err}
+		verdictsMu.Unlock()
+	}
+	return format, err
+}
+
+// verifyFile verifies the file at path.
+func verifyFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return verify(f, info.Size())
+}
+
+// verifyEntry verifies the archive entry at path.
+func verifyEntry(path string) (string, error) {
+	// Archive entries are not seekable; images are small enough to buffer
+	rc, err := vfs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", err
+	}
+	return verify(bytes.NewReader(data), int64(len(data)))
+}
+
+// imageReader is the random access verify needs, provided by files and byte readers.
+type imageReader interface {
+	io.ReadSeeker
+	io.ReaderAt
+}
+
+// verify checks an image of the given size read from f.
+func verify(f imageReader, size int64) (string, error) {
+	if size == 0 {
+		return "", ErrTruncated
+	}
+
+	header := make([]byte, 16)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	format := detectFormat(header[:n])
+	if format == "" {
+		return "", ErrUnknownFormat
+	}
+	if format == "avif" || format == "jxl" {
+		return format, nil
+	}
+
+	// DecodeConfig parses the header only, which catches corrupt headers cheaply
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, _, err := image.DecodeConfig(f); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return format, ErrTruncated
+		}
+		return format, fmt.Errorf("corrupt %s header: %w", format, err)
+	}
+
+	if err := checkTrailer(f, size, format); err != nil {
+		return format, err
+	}
+
+	return format, nil
+}
+
+// checkTrailer looks for the end-of-image marker of the given format
+// to detect files that were cut off mid-write or mid-download.
+func checkTrailer(f io.ReaderAt, size int64, format string) error {
+	switch format {
+	case "webp":
+		// The RIFF header records the payload size; the file must hold all of it
+		var riff [8]byte
+		if _, err := f.ReadAt(riff[:], 0); err != nil {
+			return err
+		}
+		if size < int64(binary.LittleEndian.Uint32(riff[4:]))+8 {
+			return ErrTruncated
+		}
+		return nil
+	case "bmp":
+		// The file header records the total file size
+		var header [6]byte
+		if _, err := f.ReadAt(header[:], 0); err != nil {
+			return err
+		}
+		if size < int64(binary.LittleEndian.Uint32(header[2:])) {
+			return ErrTruncated
+		}
+		return nil
+	case "tiff":
+		// Image data is referenced by offsets rather than terminated by a marker
+		return nil
+	case "jpeg":
+		// Cameras and phones append previews and maker data after the image, so
+		// the end marker is searched for anywhere after the compressed data begins
+		start, err := jpegScanStart(f, size)
+		if err != nil {
+			return err
+		}
+		return findMarker(f, start, size, []byte{0xFF, 0xD9}) // EOI
+	case "png":
+		return findMarker(f, 8, size, []byte("IEND"))
+	case "gif":
+		tailLen := min(size, trailerSize)
+		tail := make([]byte, tailLen)
+		if _, err := f.ReadAt(tail, size-tailLen); err != nil {
+			return err
+		}
+		// Trailer, possibly followed by padding
+		if !bytes.HasSuffix(bytes.TrimRight(tail, "\x00"), []byte{0x3B}) {
+			return ErrTruncated
+		}
+	}
+	return nil
+}
+
+// jpegScanStart returns the offset of the start-of-scan marker of a JPEG, after
+// which the compressed image data follows. Earlier segments may embed
+// thumbnails with end markers of their own, so those are skipped.
+func jpegScanStart(f io.ReaderAt, size int64) (int64, error) {
+	offset := int64(2)
+	for offset+4 <= size {
+		var segment [4]byte
+		if _, err := f.ReadAt(segment[:], offset); err != nil {
+			return 0, err
+		}
+		if segment[0] != 0xFF {
+			return 0, fmt.Errorf("corrupt jpeg segment at offset %d", offset)
+		}
+		switch {
+		case segment[1] == 0xFF:
+			offset++ // Fill byte
+			continue
+		case segment[1] == 0xDA: // SOS
+			return offset, nil
+		case segment[1] == 0x01 || (segment[1] >= 0xD0 && segment[1] <= 0xD7):
+			offset += 2 // Markers without a length
+			continue
+		}
+		offset += 2 + int64(binary.BigEndian.Uint16(segment[2:]))
+	}
+	return 0, ErrTruncated
+}
+
+// findMarker searches f backwards from its end for marker, stopping at offset
+// from, in chunks of trailerSize. Intact files normally have it in the last chunk.
+func findMarker(f io.ReaderAt, from, size int64, marker []byte) error {
+	overlap := int64(len(marker) - 1)
+	buf := make([]byte, trailerSize+overlap)
+	for end := size; end > from; end -= trailerSize {
+		start := max(from, end-trailerSize)
+		chunk := buf[:min(end+overlap, size)-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return err
+		}
+		if bytes.Contains(chunk, marker) {
+			return nil
+		}
+	}
+	return ErrTruncated
+}
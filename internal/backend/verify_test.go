@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"waller/internal/vfs"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// encodeImage returns a small encoded test image in the given format.
+func encodeImage(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "bmp":
+		err = bmp.Encode(&buf, img)
+	case "tiff":
+		err = tiff.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestVerifyImage verifies content sniffing and truncation detection.
+func TestVerifyImage(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	jpg := encodeImage(t, "jpeg")
+	gifData := encodeImage(t, "gif")
+	bmpData := encodeImage(t, "bmp")
+	// An APP1 segment holding an embedded preview, whose end marker must not count
+	app1 := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x06, 0xFF, 0xD8, 0xFF, 0xD9}
+	withPreview := append(app1, jpg[2:]...)
+	files := map[string][]byte{
+		"good.gif":      gifData,
+		"good.bmp":      bmpData,
+		"good.tiff":     encodeImage(t, "tiff"),
+		"good.avif":     []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"),
+		"truncated.gif": gifData[:len(gifData)-4],
+		"truncated.bmp": bmpData[:len(bmpData)-16],
+		"good.jpg":      jpg,
+		"renamed.webp":  encodeImage(t, "png"),
+		"truncated.jpg": jpg[:len(jpg)/2],
+		"trailer.jpg":   append(slices.Clone(jpg), bytes.Repeat([]byte{0xAB}, 10000)...),
+		"preview.jpg":   withPreview[:len(withPreview)-8],
+		"text.png":      []byte("not an image"),
+		"empty.jpeg":    nil,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), data, 0644); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		wantFormat string
+		wantErr    error
+	}{
+		{name: "good.jpg", wantFormat: "jpeg"},
+		{name: "good.gif", wantFormat: "gif"},
+		{name: "good.bmp", wantFormat: "bmp"},
+		{name: "good.tiff", wantFormat: "tiff"},
+		{name: "good.avif", wantFormat: "avif"},
+		{name: "truncated.gif", wantErr: ErrTruncated},
+		{name: "truncated.bmp", wantErr: ErrTruncated},
+		{name: "renamed.webp", wantFormat: "png"},
+		{name: "truncated.jpg", wantErr: ErrTruncated},
+		{name: "trailer.jpg", wantFormat: "jpeg"},
+		{name: "preview.jpg", wantErr: ErrTruncated},
+		{name: "text.png", wantErr: ErrUnknownFormat},
+		{name: "empty.jpeg", wantErr: ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		// Act
+		format, err := VerifyImage(filepath.Join(tmpDir, tt.name))
+
+		// Assert
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		}
+		if format != tt.wantFormat {
+			t.Errorf("%s: expected format %q, got %q", tt.name, tt.wantFormat, format)
+		}
+	}
+}
+
+// TestScanVerifyReportsSkipped verifies that a verified scan lists skipped files with reasons.
+func TestScanVerifyReportsSkipped(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "good.png"), encodeImage(t, "png"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "bad.jpg"), []byte("fake content"), 0644)
+
+	// Act
+	result, err := Scan([]string{tmpDir}, Options{Verify: true})
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(result.Wallpapers) != 1 {
+		t.Errorf("Expected 1 wallpaper, found %d", len(result.Wallpapers))
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Reason != ErrUnknownFormat.Error() {
+		t.Errorf("Expected bad.jpg to be skipped as unknown format, got %+v", result.Skipped)
+	}
+}
+
+// writeZipEntry writes a zip archive at path holding data as the entry name.
+func writeZipEntry(t *testing.T, path, name string, data []byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create(name)
+	w.Write(data)
+	zw.Close()
+	f.Close()
+}
+
+// TestVerifyArchiveEntry verifies that archive entries are checked once while
+// their archive is unchanged and again once it is replaced.
+func TestVerifyArchiveEntry(t *testing.T) {
+	// Arrange
+	archive := filepath.Join(t.TempDir(), "pack.zip")
+	jpg := encodeImage(t, "jpeg")
+	writeZipEntry(t, archive, "a.jpg", jpg)
+	entry := vfs.Join(archive, "a.jpg")
+
+	// Act
+	_, firstErr := VerifyImage(entry)
+	verdictsMu.Lock()
+	_, cached := verdicts[entry]
+	verdictsMu.Unlock()
+	writeZipEntry(t, archive, "a.jpg", jpg[:len(jpg)/2])
+	os.Chtimes(archive, time.Now(), time.Now().Add(time.Hour))
+	_, replacedErr := VerifyImage(entry)
+
+	// Assert
+	if firstErr != nil || !cached {
+		t.Errorf("Expected the entry to pass and be remembered, got %v, cached %v", firstErr, cached)
+	}
+	if !errors.Is(replacedErr, ErrTruncated) {
+		t.Errorf("Expected the replaced entry to be checked again, got %v", replacedErr)
+	}
+}
+
+// TestVerifyImageCached verifies that a file is checked once while it is
+// unchanged and again once it changes.
+func TestVerifyImageCached(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "a.jpg")
+	jpg := encodeImage(t, "jpeg")
+	os.WriteFile(path, jpg, 0644)
+	info, _ := os.Stat(path)
+
+	// Act: damage the file without changing its size or modification time
+	_, firstErr := VerifyImage(path)
+	damaged := slices.Clone(jpg)
+	damaged[len(damaged)-1] = 0
+	os.WriteFile(path, damaged, 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	_, cachedErr := VerifyImage(path)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Hour))
+	_, changedErr := VerifyImage(path)
+
+	// Assert
+	if firstErr != nil || cachedErr != nil {
+		t.Errorf("Expected the unchanged file to keep passing, got %v, %v", firstErr, cachedErr)
+	}
+	if !errors.Is(changedErr, ErrTruncated) {
+		t.Errorf("Expected the changed file to be checked again, got %v", changedErr)
+	}
+}
@@ -1,22 +1,37 @@
 // Package cache provides thumbnail generation and caching for wallpaper images.
-// Thumbnails are stored in the user's cache directory and indexed by MD5 hash.
+// Thumbnails are stored in the user's cache directory, as files or in a single
+// pack file, and indexed by MD5 hash, together with metadata derived from them
+// such as the dominant colors.
+// The metadata records the size and modification time of the source image,
+// so a thumbnail is regenerated once its image is edited or replaced.
 package cache
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+
+	"waller/internal/vfs"
 
 	"github.com/nfnt/resize"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
 	_ "golang.org/x/image/webp"
 )
 
+// DefaultWidth is the width of thumbnails in pixels when none is asked for.
+// Colors and hashes are computed from thumbnails of this width.
+const DefaultWidth = 200
+
 // thumbDir is resolved once at first use to avoid repeated syscalls.
 var (
 	thumbDir     string
@@ -24,7 +39,8 @@ var (
 	thumbDirErr  error
 )
 
-// initThumbDir resolves and creates the thumbnail cache directory.
+// initThumbDir resolves and creates the thumbnail cache directory and opens
+// the thumbnail store in it.
 func initThumbDir() {
 	thumbDirOnce.Do(func() {
 		cacheDir, err := os.UserCacheDir()
@@ -33,54 +49,148 @@ func initThumbDir() {
 			return
 		}
 		thumbDir = filepath.Join(cacheDir, "waller", "thumbnails")
-		thumbDirErr = os.MkdirAll(thumbDir, 0755)
+		if thumbDirErr = os.MkdirAll(thumbDir, 0755); thumbDirErr != nil {
+			return
+		}
+		thumbStore, thumbDirErr = openStore()
 	})
 }
 
-// GetThumbnail returns the path to a cached thumbnail for the given image path.
-// If the thumbnail does not exist, it generates one.
-// fastCheck: if true, only checks existence, does not generate (returns error if missing).
-func GetThumbnail(originalPath string, fastCheck bool) (string, error) {
+// thumbKey returns the cache key of the image at originalPath.
+func thumbKey(originalPath string) string {
+	hash := md5.Sum([]byte(originalPath))
+	return hex.EncodeToString(hash[:])
+}
+
+// GetThumbnail returns the JPEG data of a cached thumbnail for the given image path,
+// width pixels wide (DefaultWidth if width is 0). Images narrower than that are not enlarged.
+// If the thumbnail does not exist, is incomplete, or the image changed since it was made,
+// it generates one. Concurrent calls for the same image share a single generation.
+// fastCheck: if true, only checks existence, does not generate (returns error if missing or stale).
+func GetThumbnail(originalPath string, width int, fastCheck bool) ([]byte, error) {
 	initThumbDir()
 	if thumbDirErr != nil {
-		return "", thumbDirErr
+		return nil, thumbDirErr
+	}
+	if width <= 0 {
+		width = DefaultWidth
 	}
 
-	hash := md5.Sum([]byte(originalPath))
-	hashStr := hex.EncodeToString(hash[:])
-	thumbPath := filepath.Join(thumbDir, hashStr+".jpg")
+	info, err := vfs.Stat(originalPath)
+	if err != nil {
+		return nil, err
+	}
 
-	// Check if exists
-	if _, err := os.Stat(thumbPath); err == nil {
-		return thumbPath, nil
+	key := thumbKey(originalPath)
+	if data, _ := cached(key, width, info); data != nil {
+		touch(key)
+		return data, nil
 	}
 
 	if fastCheck {
-		return "", errors.New("thumbnail not found")
+		return nil, errors.New("thumbnail not found or out of date")
 	}
 
-	// Generate
-	file, err := os.Open(originalPath)
-	if err != nil {
-		return "", err
+	f, leader := join(key, width)
+	if !leader {
+		<-f.done
+		if f.width == width {
+			return f.data, f.err
+		}
+		// Another width of the image was made meanwhile; ours may still be missing
+		return GetThumbnail(originalPath, width, false)
 	}
-	defer file.Close()
+	defer leave(key, f)
 
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return "", err
+	// It may have been generated between the check and joining
+	data, fresh := cached(key, width, info)
+	if data != nil {
+		f.data = data
+		return f.data, nil
+	}
+	f.data, f.err = generate(originalPath, key, width, info, fresh)
+	return f.data, f.err
+}
+
+// cached returns the stored thumbnail of the given width if it is complete and
+// was made from the version of the image described by info. fresh reports the
+// latter alone.
+func cached(key string, width int, info os.FileInfo) (data []byte, fresh bool) {
+	m, found := getMeta(key)
+	if !found || !m.describes(info) {
+		return nil, false
 	}
+	data, _ = thumbStore.read(key, width)
+	return data, true
+}
 
-	// Resize to width 200 (preserving aspect ratio)
-	// NearestNeighbor is faster and uses less memory than Lanczos3
-	m := resize.Resize(200, 0, img, resize.NearestNeighbor)
+// generate makes the thumbnail of the image at originalPath, width pixels wide,
+// and stores it under key. Unless fresh, thumbnails of other widths were made from
+// an older version of the image and are removed first.
+func generate(originalPath, key string, width int, info os.FileInfo, fresh bool) ([]byte, error) {
+	if !fresh {
+		thumbStore.removeSizes(key)
+	}
 
-	out, err := os.Create(thumbPath)
-	if err != nil {
-		return "", err
+	// Prefer a thumbnail another application already made
+	var thumb image.Image
+	if img := readShared(originalPath, info, width); img != nil {
+		thumb = resize.Resize(uint(min(width, img.Bounds().Dx())), 0, img, resize.Lanczos3)
+	} else {
+		var err error
+		if thumb, err = decodeThumbnail(originalPath, info, width); err != nil {
+			return nil, err
+		}
 	}
-	defer out.Close()
 
 	// Save as JPEG with quality 75 (reduces file size, imperceptible at thumbnail size)
-	return thumbPath, jpeg.Encode(out, m, &jpeg.Options{Quality: 75})
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	if err := thumbStore.write(key, width, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// The small image is cheap to analyze, so colors are extracted here
+	meta := analyze(thumb)
+	meta.Size, meta.ModTime = info.Size(), info.ModTime()
+	meta.Path, meta.LastUsed = originalPath, time.Now()
+	setMeta(key, meta)
+	return buf.Bytes(), nil
+}
+
+// flight is a thumbnail generation in progress. Its result is set before done is closed.
+type flight struct {
+	width int
+	done  chan struct{}
+	data  []byte
+	err   error
+}
+
+// flights holds the generation in progress for each image key.
+var (
+	flightsMu sync.Mutex
+	flights   = make(map[string]*flight)
+)
+
+// join returns the generation in progress for key, or registers a new one
+// for width, in which case leader is true and the caller must call leave.
+func join(key string, width int) (f *flight, leader bool) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	if f, ok := flights[key]; ok {
+		return f, false
+	}
+	f = &flight{width: width, done: make(chan struct{})}
+	flights[key] = f
+	return f, true
+}
+
+// leave ends the generation f for key and wakes the callers waiting on it.
+func leave(key string, f *flight) {
+	flightsMu.Lock()
+	delete(flights, key)
+	flightsMu.Unlock()
+	close(f.done)
 }
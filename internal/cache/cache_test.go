@@ -0,0 +1,348 @@
+package cache
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTempCache points the thumbnail cache at an empty directory for the test.
+func useTempCache(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	thumbDirOnce = sync.Once{}
+	metaMu.Lock()
+	metaStore, metaLoaded, metaDirty, metaRemoved, metaCleared = nil, false, false, nil, false
+	metaMu.Unlock()
+	t.Cleanup(func() {
+		if thumbStore != nil {
+			thumbStore.close()
+			thumbStore = nil
+		}
+	})
+}
+
+// thumbFilePath returns where the thumbnail of the image at path is stored in
+// the per-file layout.
+func thumbFilePath(path string, width int) string {
+	return (&dirStore{dir: thumbDir}).path(thumbKey(path), width)
+}
+
+// writePNG writes a solid image of the given size and color to path.
+func writePNG(t *testing.T, path string, w, h int, c color.Color) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// TestThumbnailInvalidation verifies that replacing an image regenerates its thumbnail.
+func TestThumbnailInvalidation(t *testing.T) {
+	// Arrange: a cached thumbnail of a red image
+	useTempCache(t)
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 40, 20, color.RGBA{255, 0, 0, 255})
+	if _, err := GetThumbnail(path, 0, false); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	before, _ := GetMeta(path)
+
+	// Act: replace the image with a blue one of another size, as an editor would
+	writePNG(t, path, 60, 20, color.RGBA{0, 0, 255, 255})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	_, fastErr := GetThumbnail(path, 0, true)
+	after, err := Analyze(path)
+
+	// Assert
+	if fastErr == nil {
+		t.Errorf("Expected the fast check to report the stale thumbnail")
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after.Size == before.Size || !after.ModTime.Equal(later) {
+		t.Errorf("Expected metadata of the new file, got size %d, mtime %v", after.Size, after.ModTime)
+	}
+	if got := after.Palette[0].B; got < 200 {
+		t.Errorf("Expected a blue dominant color after regeneration, got %+v", after.Palette[0])
+	}
+	if _, err := GetThumbnail(path, 0, true); err != nil {
+		t.Errorf("Expected the regenerated thumbnail to be current, got %v", err)
+	}
+}
+
+// TestThumbnailLegacyMeta verifies that thumbnails recorded without the source
+// size and mtime are regenerated once.
+func TestThumbnailLegacyMeta(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 40, 20, color.RGBA{0, 255, 0, 255})
+	if _, err := GetThumbnail(path, 0, false); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	m, _ := GetMeta(path)
+	m.Size, m.ModTime = 0, time.Time{}
+	setMeta(thumbKey(path), m)
+
+	// Act
+	_, fastErr := GetThumbnail(path, 0, true)
+	_, err := GetThumbnail(path, 0, false)
+	_, refreshedErr := GetThumbnail(path, 0, true)
+
+	// Assert
+	if fastErr == nil {
+		t.Errorf("Expected a legacy thumbnail to count as stale")
+	}
+	if err != nil || refreshedErr != nil {
+		t.Errorf("Expected regeneration to succeed, got %v, %v", err, refreshedErr)
+	}
+}
+
+// TestThumbnailSizes verifies per-width thumbnails, that small images are not
+// enlarged, and that a changed image invalidates every width.
+func TestThumbnailSizes(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 300, 200, color.RGBA{255, 0, 0, 255})
+
+	// Act
+	small, err := GetThumbnail(path, 0, false)
+	large, largeErr := GetThumbnail(path, 600, false)
+
+	// Assert
+	if err != nil || largeErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", err, largeErr)
+	}
+	largePath := thumbFilePath(path, 600)
+	if _, err := os.Stat(largePath); err != nil || bytes.Equal(small, large) {
+		t.Errorf("Expected a separate file per width, got %v", err)
+	}
+	if w := imageWidth(t, small); w != DefaultWidth {
+		t.Errorf("Expected the default width %d, got %d", DefaultWidth, w)
+	}
+	if w := imageWidth(t, large); w != 300 {
+		t.Errorf("Expected the 300px image not to be enlarged, got width %d", w)
+	}
+
+	// Act: change the image and regenerate one width
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if _, err := GetThumbnail(path, 0, false); err != nil {
+		t.Fatalf("Regeneration failed: %v", err)
+	}
+
+	// Assert
+	if _, err := os.Stat(largePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale 600px thumbnail to be removed, got %v", err)
+	}
+}
+
+// imageWidth returns the width of the encoded image data.
+func imageWidth(t *testing.T, data []byte) int {
+	t.Helper()
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	return cfg.Width
+}
+
+// TestConcurrentThumbnails verifies that simultaneous requests for one image
+// share a generation and leave a single complete thumbnail behind.
+func TestConcurrentThumbnails(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 800, 600, color.RGBA{0, 128, 255, 255})
+
+	// Act
+	var wg sync.WaitGroup
+	results := make([][]byte, 16)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = GetThumbnail(path, 0, false)
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	for i := range results {
+		if errs[i] != nil || !bytes.Equal(results[i], results[0]) {
+			t.Fatalf("Expected every caller to get the same thumbnail, caller %d got %v", i, errs[i])
+		}
+	}
+	if !intact(results[0]) {
+		t.Errorf("Expected a complete thumbnail")
+	}
+	entries, _ := os.ReadDir(thumbDir)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Errorf("Expected no temporary files, found %s", entry.Name())
+		}
+	}
+}
+
+// TestTruncatedThumbnail verifies that a thumbnail cut short is not served
+// and is regenerated.
+func TestTruncatedThumbnail(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 400, 300, color.RGBA{0, 255, 0, 255})
+	thumb, err := GetThumbnail(path, 0, false)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.Truncate(thumbFilePath(path, DefaultWidth), int64(len(thumb)/2)); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	_, fastErr := GetThumbnail(path, 0, true)
+	regenerated, err := GetThumbnail(path, 0, false)
+
+	// Assert
+	if fastErr == nil {
+		t.Errorf("Expected the truncated thumbnail to be reported missing")
+	}
+	if err != nil || !intact(regenerated) {
+		t.Errorf("Expected a complete regenerated thumbnail, got %v", err)
+	}
+}
+
+// TestSaveMetaRetriesAfterFailure verifies that metadata whose save failed is
+// written by the next save.
+func TestSaveMetaRetriesAfterFailure(t *testing.T) {
+	// Arrange: a non-empty directory stands where the metadata file goes
+	useTempCache(t)
+	paths := cachedImages(t, 1)
+	os.MkdirAll(filepath.Join(metaPath(), "blocker"), 0755)
+
+	// Act
+	failErr := SaveMeta()
+	os.RemoveAll(metaPath())
+	retryErr := SaveMeta()
+	metaMu.Lock()
+	metaStore, metaLoaded = nil, false
+	metaMu.Unlock()
+	_, found := GetMeta(paths[0])
+
+	// Assert
+	if failErr == nil {
+		t.Fatalf("Expected the blocked save to fail")
+	}
+	if retryErr != nil || !found {
+		t.Errorf("Expected the retry to save the metadata, got %v", retryErr)
+	}
+}
+
+// TestDuplicatesSymlink verifies that a symlink and its target are not
+// reported as duplicates of each other, and that a group with another copy
+// lists the target rather than the symlink.
+func TestDuplicatesSymlink(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	dir := t.TempDir()
+	target := filepath.Join(dir, "wall.png")
+	link := filepath.Join(dir, "link.png")
+	cp := filepath.Join(dir, "copy.png")
+	writePNG(t, target, 64, 48, color.RGBA{200, 40, 40, 255})
+	writePNG(t, cp, 64, 48, color.RGBA{200, 40, 40, 255})
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	alone := Duplicates([]string{link, target}, 0)
+	withCopy := Duplicates([]string{link, target, cp}, 0)
+
+	// Assert
+	if len(alone) != 0 {
+		t.Errorf("Expected no duplicates of a symlink and its target, got %v", alone)
+	}
+	if len(withCopy) != 1 || len(withCopy[0]) != 2 || withCopy[0][0] != target || withCopy[0][1] != cp {
+		t.Errorf("Expected the target and the copy as one group, got %v", withCopy)
+	}
+}
+
+// TestSaveMetaMerges verifies that two processes saving the metadata in turn
+// keep each other's entries, the newer of two versions and removals.
+func TestSaveMetaMerges(t *testing.T) {
+	// Arrange: both processes loaded the same store
+	useTempCache(t)
+	initThumbDir()
+	old, changed, used := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), time.Now()
+	loaded := map[string]Meta{
+		"shared": {PHash: "1", ModTime: old, LastUsed: old},
+		"gone":   {PHash: "2", ModTime: old},
+	}
+	switchTo := func(store map[string]Meta) {
+		metaMu.Lock()
+		metaStore, metaLoaded, metaDirty, metaRemoved, metaStamp = store, true, true, nil, time.Time{}
+		metaMu.Unlock()
+	}
+	switchTo(maps.Clone(loaded))
+	SaveMeta()
+
+	// Act: a build regenerates one entry and adds another, then the GUI,
+	// which has used the first and deleted a third, saves after it
+	build := maps.Clone(loaded)
+	build["shared"] = Meta{PHash: "3", ModTime: changed, LastUsed: changed}
+	build["built"] = Meta{PHash: "4", ModTime: changed}
+	switchTo(build)
+	buildErr := SaveMeta()
+	gui := maps.Clone(loaded)
+	gui["shared"] = Meta{PHash: "1", ModTime: old, LastUsed: used}
+	switchTo(gui)
+	_, seen := getMeta("built")
+	metaMu.Lock()
+	deleteMeta("gone")
+	metaMu.Unlock()
+	guiErr := SaveMeta()
+	metaMu.Lock()
+	metaStore, metaLoaded = nil, false
+	metaMu.Unlock()
+	shared, _ := getMeta("shared")
+	_, built := getMeta("built")
+	_, gone := getMeta("gone")
+
+	// Assert
+	if buildErr != nil || guiErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", buildErr, guiErr)
+	}
+	if !seen {
+		t.Errorf("Expected the GUI to find the entry the build saved")
+	}
+	if shared.PHash != "3" || !shared.LastUsed.Equal(used) {
+		t.Errorf("Expected the regenerated entry with the later use, got %+v", shared)
+	}
+	if !built || gone {
+		t.Errorf("Expected the built entry kept and the deleted one gone, got %v, %v", built, gone)
+	}
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"waller/internal/convert"
+	"waller/internal/vfs"
+
+	"github.com/nfnt/resize"
+)
+
+// decodeBudget caps the memory, in bytes, of the images being decoded at once
+// across all thumbnail workers. A larger image is decoded on its own.
+const decodeBudget = 512 << 20
+
+// ScaledDecoder decodes the image file at path to about width by height pixels,
+// preserving its aspect ratio and decoding at reduced resolution where the
+// format allows it, such as JPEG DCT scaling.
+type ScaledDecoder func(path string, width, height int) (image.Image, error)
+
+var scaledDecoder atomic.Pointer[ScaledDecoder]
+
+// SetScaledDecoder registers d for decoding JPEG images much larger than
+// their thumbnail. Without one, images are decoded at full size.
+func SetScaledDecoder(d ScaledDecoder) {
+	scaledDecoder.Store(&d)
+}
+
+// memLimiter is a weighted semaphore over bytes of decoded image data.
+type memLimiter struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	used  int64
+	limit int64
+}
+
+// newMemLimiter returns a limiter admitting limit bytes at once.
+func newMemLimiter(limit int64) *memLimiter {
+	l := &memLimiter{limit: limit}
+	l.cond.L = &l.mu
+	return l
+}
+
+// acquire blocks until n bytes are available and reserves them. Requests over
+// the limit wait until nothing else is reserved. It returns the amount to release.
+func (l *memLimiter) acquire(n int64) int64 {
+	n = min(n, l.limit)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.used+n > l.limit {
+		l.cond.Wait()
+	}
+	l.used += n
+	return n
+}
+
+// release returns n reserved bytes.
+func (l *memLimiter) release(n int64) {
+	l.mu.Lock()
+	l.used -= n
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// decodeMemory bounds the decodes of all thumbnail workers.
+var decodeMemory = newMemLimiter(decodeBudget)
+
+// decodeThumbnail decodes the image at path and returns it resized to width
+// (preserving aspect ratio, never enlarged). The dimensions are read first so
+// the decode can wait for memory and, for large JPEGs, run at reduced resolution.
+// The full image is also written to the shared thumbnail cache if enabled.
+func decodeThumbnail(path string, info os.FileInfo, width int) (image.Image, error) {
+	source, err := renderable(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := decodeConfig(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shared thumbnail needs more pixels than a small one of ours
+	target := width
+	if shareThumbnails.Load() {
+		target = max(width, sharedSizes[1].px)
+	}
+
+	img, err := decode(source, format, cfg, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if shareThumbnails.Load() {
+		if err := writeShared(path, info, img, cfg.Width, cfg.Height); err != nil {
+			slog.Debug("Could not write shared thumbnail", "path", path, "error", err)
+		}
+	}
+	// Resize to width; Lanczos keeps edges sharp without the aliasing of
+	// nearest-neighbor sampling
+	return resize.Resize(uint(min(width, img.Bounds().Dx())), 0, img, resize.Lanczos3), nil
+}
+
+// DecodeScaled decodes the image at path to at least width by height pixels,
+// preserving its aspect ratio, or at full size if it is smaller. Like thumbnail
+// decodes it waits for memory and runs at reduced resolution where it can.
+func DecodeScaled(path string, width, height int) (image.Image, error) {
+	source, err := renderable(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := decodeConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%s: empty image", path)
+	}
+	// The side that scales least decides how wide the decode must be
+	target := max(width, (height*cfg.Width+cfg.Height-1)/cfg.Height)
+	return decode(source, format, cfg, target)
+}
+
+// decode decodes the image at path, of the given format and dimensions, about
+// width pixels wide if it can be scaled while decoding and at full size otherwise.
+func decode(path, format string, cfg image.Config, width int) (image.Image, error) {
+	if img := decodeScaled(path, format, cfg, width); img != nil {
+		return img, nil
+	}
+	n := decodeMemory.acquire(4 * int64(cfg.Width) * int64(cfg.Height))
+	defer decodeMemory.release(n)
+	return decodeFile(path)
+}
+
+// decodeScaled decodes a JPEG at least twice as wide as width at reduced
+// resolution, about width pixels wide, with the registered ScaledDecoder.
+// It returns nil if that is not possible, so the image is decoded at full size.
+func decodeScaled(path, format string, cfg image.Config, width int) image.Image {
+	d := scaledDecoder.Load()
+	if d == nil || format != "jpeg" || cfg.Width < 2*width || vfs.IsVirtual(path) {
+		return nil
+	}
+	height := max(cfg.Height*width/cfg.Width, 1)
+
+	// DCT scaling decodes to between one and two times the requested size
+	n := decodeMemory.acquire(4 * int64(2*width) * int64(2*height))
+	defer decodeMemory.release(n)
+	img, err := (*d)(path, width, height)
+	if err != nil {
+		slog.Debug("Scaled decoding failed, decoding at full size", "path", path, "error", err)
+		return nil
+	}
+	return img
+}
+
+// renderable returns the path to decode for the image at path. Formats
+// without a Go decoder are read from their converted copy.
+func renderable(path string) (string, error) {
+	if !convert.NeedsConversion(path) {
+		return path, nil
+	}
+	local, err := vfs.Extract(path)
+	if err != nil {
+		return "", err
+	}
+	return convert.Renderable(local)
+}
+
+// decodeConfig reads the dimensions and format of the image at path.
+func decodeConfig(path string) (image.Config, string, error) {
+	file, err := vfs.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer file.Close()
+	return image.DecodeConfig(file)
+}
+
+// decodeFile decodes the image at path at full size.
+func decodeFile(path string) (image.Image, error) {
+	file, err := vfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestMemLimiter verifies that reservations wait for memory and that an
+// oversized request runs once nothing else is reserved.
+func TestMemLimiter(t *testing.T) {
+	// Arrange
+	l := newMemLimiter(100)
+	first := l.acquire(60)
+	admitted := make(chan int64)
+
+	// Act
+	go func() { admitted <- l.acquire(1000) }()
+
+	// Assert
+	select {
+	case <-admitted:
+		t.Fatalf("Expected the oversized request to wait while memory is reserved")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release(first)
+	select {
+	case n := <-admitted:
+		if n != 100 {
+			t.Errorf("Expected the request to be clamped to the limit, got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the request to be admitted after the release")
+	}
+}
+
+// TestScaledDecoding verifies that large JPEGs go through the scaled decoder
+// at the thumbnail size and other images are decoded directly.
+func TestScaledDecoding(t *testing.T) {
+	// Arrange: a 1000x500 JPEG and PNG, and a decoder that records its requests
+	useTempCache(t)
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	f, err := os.Create(photo)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 1000, 500)), nil); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	f.Close()
+	drawing := filepath.Join(dir, "drawing.png")
+	writePNG(t, drawing, 1000, 500, color.RGBA{0, 0, 255, 255})
+
+	type request struct {
+		path string
+		w, h int
+	}
+	var requests []request
+	SetScaledDecoder(func(path string, w, h int) (image.Image, error) {
+		requests = append(requests, request{path, w, h})
+		return image.NewRGBA(image.Rect(0, 0, w, h)), nil
+	})
+	t.Cleanup(func() { scaledDecoder.Store(nil) })
+
+	// Act
+	_, photoErr := GetThumbnail(photo, 0, false)
+	_, drawingErr := GetThumbnail(drawing, 0, false)
+
+	// Assert
+	if photoErr != nil || drawingErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", photoErr, drawingErr)
+	}
+	if len(requests) != 1 || requests[0] != (request{photo, DefaultWidth, 100}) {
+		t.Errorf("Expected one scaled decode of the photo at %dx100, got %+v", DefaultWidth, requests)
+	}
+}
+
+// TestDecodeScaled verifies that an image is decoded just large enough to
+// cover the requested size, and at full size when it is not much larger.
+func TestDecodeScaled(t *testing.T) {
+	// Arrange: a 1000x500 JPEG and a decoder that records its requests
+	photo := filepath.Join(t.TempDir(), "photo.jpg")
+	f, err := os.Create(photo)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 1000, 500)), nil); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	f.Close()
+	var widths []int
+	SetScaledDecoder(func(path string, w, h int) (image.Image, error) {
+		widths = append(widths, w)
+		return image.NewRGBA(image.Rect(0, 0, w, h)), nil
+	})
+	t.Cleanup(func() { scaledDecoder.Store(nil) })
+
+	// Act: a tall target needs more width than asked for to cover its height
+	tall, tallErr := DecodeScaled(photo, 100, 200)
+	full, fullErr := DecodeScaled(photo, 800, 400)
+
+	// Assert
+	if tallErr != nil || tall.Bounds().Dx() != 400 {
+		t.Errorf("Expected a 400px wide scaled decode, got %v, %v", tall.Bounds(), tallErr)
+	}
+	if fullErr != nil || full.Bounds().Dx() != 1000 {
+		t.Errorf("Expected a full-size decode, got %v, %v", full.Bounds(), fullErr)
+	}
+	if !slices.Equal(widths, []int{400}) {
+		t.Errorf("Expected one scaled decode at 400px, got %v", widths)
+	}
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"waller/internal/vfs"
+
+	"github.com/nfnt/resize"
+)
+
+// Thumbnails shared with file managers and other applications follow the
+// freedesktop.org thumbnail specification: PNGs in ~/.cache/thumbnails/<size>,
+// named by the MD5 of the image URI, with the URI and mtime in tEXt chunks.
+
+// sharedSize is a thumbnail size directory of the specification.
+type sharedSize struct {
+	dir string
+	px  int // Longest side
+}
+
+// sharedSizes lists the specification's sizes from smallest to largest.
+var sharedSizes = []sharedSize{
+	{"normal", 128},
+	{"large", 256},
+	{"x-large", 512},
+	{"xx-large", 1024},
+}
+
+// shareThumbnails makes generated thumbnails available to other applications too.
+var shareThumbnails atomic.Bool
+
+// ShareThumbnails sets whether thumbnails generated from an image are also
+// written to the shared freedesktop.org thumbnail cache. Existing shared
+// thumbnails are always used.
+func ShareThumbnails(on bool) {
+	shareThumbnails.Store(on)
+}
+
+// pngSignature starts every PNG file.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// sharedPath returns where the shared thumbnail of uri in size directory dir is stored.
+func sharedPath(dir, uri string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := md5.Sum([]byte(uri))
+	return filepath.Join(cacheDir, "thumbnails", dir, hex.EncodeToString(hash[:])+".png"), nil
+}
+
+// fileURI returns the file:// URI of path, or "" for archive entries,
+// which have none and are never shared.
+func fileURI(path string) string {
+	if vfs.IsVirtual(path) {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return "file://" + escapePath(abs)
+}
+
+// uriSafe holds the characters other than letters and digits that GLib's
+// g_filename_to_uri leaves unescaped. The thumbnail name is a hash of the URI,
+// so only the same escaping finds the thumbnails file managers made.
+const uriSafe = "-_.~!$&'()*+,:=@/"
+
+// escapePath percent-encodes path the way g_filename_to_uri does.
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := range len(path) {
+		c := path[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte(uriSafe, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// readShared returns a valid shared thumbnail of the image at path that is at
+// least minWidth wide, or nil if there is none. A thumbnail is valid if it
+// names the image and records its current modification time.
+func readShared(path string, info os.FileInfo, minWidth int) image.Image {
+	uri := fileURI(path)
+	if uri == "" {
+		return nil
+	}
+	for _, size := range sharedSizes {
+		if size.px < minWidth {
+			continue
+		}
+		p, err := sharedPath(size.dir, uri)
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if !sharedValid(textChunks(data), path, info) {
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil || img.Bounds().Dx() < minWidth {
+			continue // Too narrow for a tall image; try the next size
+		}
+		return img
+	}
+	return nil
+}
+
+// sharedValid reports whether the tEXt fields of a shared thumbnail describe
+// the current version of the image at path.
+func sharedValid(text map[string]string, path string, info os.FileInfo) bool {
+	u, err := url.Parse(text["Thumb::URI"])
+	if err != nil || u.Scheme != "file" {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil || u.Path != abs {
+		return false
+	}
+	// Some writers store fractional seconds
+	mtime, err := strconv.ParseFloat(text["Thumb::MTime"], 64)
+	return err == nil && int64(mtime) == info.ModTime().Unix()
+}
+
+// writeShared stores a "large" shared thumbnail of img, the image at path decoded
+// at full or reduced size; width and height are its full size. Images no larger
+// than the thumbnail are skipped, as the specification asks.
+func writeShared(path string, info os.FileInfo, img image.Image, width, height int) error {
+	uri := fileURI(path)
+	if uri == "" {
+		return nil
+	}
+	size := sharedSizes[1]
+	if width <= size.px && height <= size.px {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	thumb := resize.Thumbnail(uint(size.px), uint(size.px), img, resize.Bilinear)
+	if err := png.Encode(&buf, thumb); err != nil {
+		return err
+	}
+	data, err := withText(buf.Bytes(), [][2]string{
+		{"Thumb::URI", uri},
+		{"Thumb::MTime", strconv.FormatInt(info.ModTime().Unix(), 10)},
+		{"Thumb::Size", strconv.FormatInt(info.Size(), 10)},
+		{"Thumb::Image::Width", strconv.Itoa(width)},
+		{"Thumb::Image::Height", strconv.Itoa(height)},
+		{"Software", "waller"},
+	})
+	if err != nil {
+		return err
+	}
+
+	p, err := sharedPath(size.dir, uri)
+	if err != nil {
+		return err
+	}
+	// The specification asks for private permissions on both
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "waller-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// textChunks returns the keyword/text pairs of the tEXt chunks in a PNG file.
+// Only chunks before the image data are read, where thumbnailers put them.
+func textChunks(data []byte) map[string]string {
+	text := make(map[string]string)
+	if !bytes.HasPrefix(data, pngSignature) {
+		return text
+	}
+	data = data[len(pngSignature):]
+	for len(data) >= 12 {
+		n := binary.BigEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-12) {
+			break
+		}
+		kind, body := string(data[4:8]), data[8:8+n]
+		if kind == "IDAT" || kind == "IEND" {
+			break
+		}
+		if kind == "tEXt" {
+			if key, value, ok := bytes.Cut(body, []byte{0}); ok {
+				text[string(key)] = string(value) // Latin-1, which URIs and numbers stay within
+			}
+		}
+		data = data[12+n:]
+	}
+	return text
+}
+
+// withText inserts tEXt chunks with the given keyword/text pairs after the
+// header of an encoded PNG.
+func withText(data []byte, pairs [][2]string) ([]byte, error) {
+	// The IHDR chunk is always first and 13 bytes long
+	const headerEnd = 8 + 12 + 13
+	if len(data) < headerEnd || !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG image")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:headerEnd])
+	for _, p := range pairs {
+		writeChunk(&out, "tEXt", []byte(p[0]+"\x00"+p[1]))
+	}
+	out.Write(data[headerEnd:])
+	return out.Bytes(), nil
+}
+
+// writeChunk appends a PNG chunk of the given type to w.
+func writeChunk(w io.Writer, kind string, body []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(body)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(body)
+	w.Write(length[:])
+	io.WriteString(w, kind)
+	w.Write(body)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSharedThumbnails verifies writing spec thumbnails and validating them on read.
+func TestSharedThumbnails(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	ShareThumbnails(true)
+	t.Cleanup(func() { ShareThumbnails(false) })
+	path := filepath.Join(t.TempDir(), "wall.png")
+	writePNG(t, path, 600, 300, color.RGBA{255, 0, 0, 255})
+
+	// Act
+	_, err := GetThumbnail(path, 0, false)
+	info, _ := os.Stat(path)
+	shared, _ := sharedPath("large", fileURI(path))
+	data, readErr := os.ReadFile(shared)
+	img := readShared(path, info, DefaultWidth)
+
+	// Assert
+	if err != nil || readErr != nil {
+		t.Fatalf("Expected a shared thumbnail, got %v, %v", err, readErr)
+	}
+	text := textChunks(data)
+	if text["Thumb::URI"] != "file://"+path || text["Thumb::Image::Width"] != "600" {
+		t.Errorf("Unexpected tEXt fields %v", text)
+	}
+	if img == nil || img.Bounds().Dx() != 256 || img.Bounds().Dy() != 128 {
+		t.Fatalf("Expected a valid 256x128 shared thumbnail, got %v", img)
+	}
+
+	// Act: touch the image, which makes the shared thumbnail stale
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	info, _ = os.Stat(path)
+
+	// Assert
+	if readShared(path, info, DefaultWidth) != nil {
+		t.Errorf("Expected a thumbnail with an old Thumb::MTime to be ignored")
+	}
+}
+
+// TestFileURI verifies that shared thumbnails are named after the URI GLib
+// gives the image, so those of file managers are found.
+func TestFileURI(t *testing.T) {
+	tests := []struct {
+		path, uri, hash string
+	}{
+		{"/home/user/Pictures/Wallpaper (1).jpg", "file:///home/user/Pictures/Wallpaper%20(1).jpg", "53f11de323300f959940d41722504b22"},
+		{"/home/user/It's a Wallpaper! [#2] 50%; ü*+,=@&$~.jpg", "file:///home/user/It's%20a%20Wallpaper!%20%5B%232%5D%2050%25%3B%20%C3%BC*+,=@&$~.jpg", "7b4de60d27ed891cf845ece188c45d5f"},
+	}
+	for _, tt := range tests {
+		// Act
+		uri := fileURI(tt.path)
+		shared, _ := sharedPath("large", uri)
+
+		// Assert
+		if uri != tt.uri {
+			t.Errorf("Expected %s, got %s", tt.uri, uri)
+		}
+		if filepath.Base(shared) != tt.hash+".png" {
+			t.Errorf("Expected the thumbnail %s.png, got %s", tt.hash, filepath.Base(shared))
+		}
+	}
+}
+
+// TestThumbnailFromShared verifies that an existing spec thumbnail is used
+// instead of decoding the original.
+func TestThumbnailFromShared(t *testing.T) {
+	// Arrange: a red image whose shared thumbnail, made by another application, is blue
+	useTempCache(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wall.png")
+	writePNG(t, path, 600, 300, color.RGBA{255, 0, 0, 255})
+	info, _ := os.Stat(path)
+	blue := filepath.Join(dir, "blue.png")
+	writePNG(t, blue, 600, 300, color.RGBA{0, 0, 255, 255})
+	img, err := decodeFile(blue)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := writeShared(path, info, img, 600, 300); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	m, err := Analyze(path)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if c := m.Palette[0]; c.B < 200 || c.R > 50 {
+		t.Errorf("Expected the colors of the shared thumbnail, got %+v", c)
+	}
+}
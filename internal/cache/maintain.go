@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"waller/internal/vfs"
+)
+
+// Limits bounds the thumbnail cache. Zero fields mean no limit.
+type Limits struct {
+	// MaxSize is the total size of all thumbnails in bytes.
+	MaxSize int64
+	// MaxAge is how long a thumbnail is kept after it was last used.
+	MaxAge time.Duration
+}
+
+// Stats summarizes the thumbnail cache.
+type Stats struct {
+	Dir        string
+	Thumbnails int
+	Bytes      int64
+	// Entries is the number of images with stored metadata.
+	Entries int
+	// Oldest is the last use of the least recently used thumbnail.
+	Oldest time.Time
+}
+
+// Removal counts thumbnails removed from the cache and the space freed.
+type Removal struct {
+	Count int
+	Bytes int64
+}
+
+// thumbFile is a stored thumbnail.
+type thumbFile struct {
+	key   string
+	width int
+	size  int64
+	used  time.Time
+}
+
+// listThumbs returns every thumbnail in the cache, least recently used first.
+// Thumbnails without metadata count as last used when they were written.
+func listThumbs() ([]thumbFile, error) {
+	initThumbDir()
+	if thumbDirErr != nil {
+		return nil, thumbDirErr
+	}
+	thumbs, err := thumbStore.list()
+	if err != nil {
+		return nil, err
+	}
+
+	metaMu.Lock()
+	loadMeta()
+	for i, t := range thumbs {
+		if m, ok := metaStore[t.key]; ok && !m.LastUsed.IsZero() {
+			thumbs[i].used = m.LastUsed
+		}
+	}
+	metaMu.Unlock()
+
+	slices.SortFunc(thumbs, func(a, b thumbFile) int {
+		return a.used.Compare(b.used)
+	})
+	return thumbs, nil
+}
+
+// countSizes returns how many thumbnails, one per width, are stored for each key.
+func countSizes(thumbs []thumbFile) map[string]int {
+	sizes := make(map[string]int)
+	for _, t := range thumbs {
+		sizes[t.key]++
+	}
+	return sizes
+}
+
+// remove deletes the thumbnail t and adds it to r. sizes counts the thumbnails
+// left for each key; the metadata, which all widths share, is deleted with the last.
+func (r *Removal) remove(t thumbFile, sizes map[string]int) {
+	if err := thumbStore.remove(t.key, t.width); err != nil {
+		return
+	}
+	sizes[t.key]--
+	if sizes[t.key] <= 0 {
+		metaMu.Lock()
+		deleteMeta(t.key)
+		metaMu.Unlock()
+	}
+	r.Count++
+	r.Bytes += t.size
+}
+
+// GetStats reports the size of the thumbnail cache.
+func GetStats() (Stats, error) {
+	thumbs, err := listThumbs()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	s := Stats{Dir: thumbDir, Thumbnails: len(thumbs)}
+	for _, t := range thumbs {
+		s.Bytes += t.size
+	}
+	if len(thumbs) > 0 {
+		s.Oldest = thumbs[0].used
+	}
+	metaMu.Lock()
+	s.Entries = len(metaStore)
+	metaMu.Unlock()
+	return s, nil
+}
+
+// Prune evicts thumbnails unused for longer than l.MaxAge, then the least
+// recently used ones until the cache fits in l.MaxSize. Evicted thumbnails are
+// regenerated when their images are shown again.
+func Prune(l Limits) (Removal, error) {
+	thumbs, err := listThumbs()
+	if err != nil {
+		return Removal{}, err
+	}
+
+	var total int64
+	for _, t := range thumbs {
+		total += t.size
+	}
+
+	var r Removal
+	sizes := countSizes(thumbs)
+	for _, t := range thumbs {
+		expired := l.MaxAge > 0 && time.Since(t.used) > l.MaxAge
+		oversize := l.MaxSize > 0 && total-r.Bytes > l.MaxSize
+		if !expired && !oversize {
+			break // Sorted oldest first, so the rest are kept too
+		}
+		r.remove(t, sizes)
+	}
+	return r, tidy()
+}
+
+// tidy compacts the thumbnail store after removals and saves the metadata.
+func tidy() error {
+	if err := thumbStore.compact(); err != nil {
+		return err
+	}
+	return SaveMeta()
+}
+
+// Clear removes every thumbnail and all stored metadata.
+func Clear() (Removal, error) {
+	thumbs, err := listThumbs()
+	if err != nil {
+		return Removal{}, err
+	}
+
+	var r Removal
+	sizes := countSizes(thumbs)
+	for _, t := range thumbs {
+		r.remove(t, sizes)
+	}
+	metaMu.Lock()
+	metaStore, metaRemoved = make(map[string]Meta), nil
+	metaDirty, metaCleared = true, true
+	metaMu.Unlock()
+	return r, tidy()
+}
+
+// leftoverAge is how old a temporary file must be before Verify treats it as
+// left behind by a crash rather than a generation in progress.
+const leftoverAge = time.Hour
+
+// Verify removes orphaned thumbnails, whose image no longer exists or is unknown,
+// and corrupt ones that cannot be decoded. Metadata of deleted images that have
+// no thumbnail and temporary files left by interrupted writes are dropped as well.
+func Verify() (orphaned, corrupt Removal, err error) {
+	thumbs, err := listThumbs()
+	if err != nil {
+		return Removal{}, Removal{}, err
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(thumbDir, "*.tmp"))
+	for _, p := range leftovers {
+		if info, err := os.Stat(p); err == nil && time.Since(info.ModTime()) > leftoverAge && os.Remove(p) == nil {
+			orphaned.Count++
+			orphaned.Bytes += info.Size()
+		}
+	}
+
+	sizes := countSizes(thumbs)
+	onDisk := make(map[string]bool, len(thumbs))
+	for _, t := range thumbs {
+		onDisk[t.key] = true
+		m, ok := getMeta(t.key)
+		switch {
+		case !ok || m.Path == "" || missing(m.Path):
+			orphaned.remove(t, sizes)
+		case !decodable(t):
+			corrupt.remove(t, sizes)
+		}
+	}
+
+	metaMu.Lock()
+	var stale []string
+	for key, m := range metaStore {
+		if !onDisk[key] && (m.Path == "" || missing(m.Path)) {
+			stale = append(stale, key)
+		}
+	}
+	for _, key := range stale {
+		deleteMeta(key)
+	}
+	metaMu.Unlock()
+
+	return orphaned, corrupt, tidy()
+}
+
+// missing reports whether the image at path is gone.
+func missing(path string) bool {
+	_, err := vfs.Stat(path)
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// decodable reports whether the thumbnail t decodes completely.
+func decodable(t thumbFile) bool {
+	data, err := thumbStore.read(t.key, t.width)
+	if err != nil {
+		return false
+	}
+	_, _, err = image.Decode(bytes.NewReader(data))
+	return err == nil
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cachedImages writes n images and generates their thumbnails.
+func cachedImages(t *testing.T, n int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, string(rune('a'+i))+".png")
+		writePNG(t, paths[i], 40, 20, color.RGBA{uint8(60 * i), 0, 0, 255})
+		if _, err := GetThumbnail(paths[i], 0, false); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+	return paths
+}
+
+// setLastUsed backdates the last use of the thumbnail of path.
+func setLastUsed(path string, used time.Time) {
+	m, _ := GetMeta(path)
+	m.LastUsed = used
+	setMeta(thumbKey(path), m)
+}
+
+// TestPrune verifies age eviction and least-recently-used eviction down to the size limit.
+func TestPrune(t *testing.T) {
+	// Arrange: a expired, b used yesterday, c used last week, d just now
+	useTempCache(t)
+	paths := cachedImages(t, 4)
+	setLastUsed(paths[0], time.Now().Add(-60*24*time.Hour))
+	setLastUsed(paths[1], time.Now().Add(-24*time.Hour))
+	setLastUsed(paths[2], time.Now().Add(-7*24*time.Hour))
+	stats, err := GetStats()
+	if err != nil || stats.Thumbnails != 4 {
+		t.Fatalf("Setup failed: %+v, %v", stats, err)
+	}
+	perThumb := stats.Bytes / 4
+
+	// Act: keep at most 30 days and room for two thumbnails
+	r, err := Prune(Limits{MaxSize: 2*perThumb + perThumb/2, MaxAge: 30 * 24 * time.Hour})
+
+	// Assert: a is too old, c is the least recently used of the rest
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if r.Count != 2 {
+		t.Errorf("Expected 2 thumbnails evicted, got %d", r.Count)
+	}
+	for i, want := range []bool{false, true, false, true} {
+		_, err := GetThumbnail(paths[i], 0, true)
+		if kept := err == nil; kept != want {
+			t.Errorf("Thumbnail of %s: expected kept=%v, got %v", filepath.Base(paths[i]), want, kept)
+		}
+	}
+}
+
+// TestVerify verifies that thumbnails of deleted images and undecodable ones are removed.
+func TestVerify(t *testing.T) {
+	// Arrange: delete the first image and truncate the thumbnail of the second
+	useTempCache(t)
+	paths := cachedImages(t, 3)
+	if err := os.Remove(paths[0]); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.Truncate(thumbFilePath(paths[1], DefaultWidth), 64); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	orphaned, corrupt, err := Verify()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if orphaned.Count != 1 || corrupt.Count != 1 {
+		t.Errorf("Expected 1 orphaned and 1 corrupt thumbnail, got %d and %d", orphaned.Count, corrupt.Count)
+	}
+	if _, ok := GetMeta(paths[0]); ok {
+		t.Errorf("Expected the metadata of the deleted image to be dropped")
+	}
+	if _, err := GetThumbnail(paths[2], 0, true); err != nil {
+		t.Errorf("Expected the intact thumbnail to be kept, got %v", err)
+	}
+}
+
+// TestClear verifies that clearing empties the cache.
+func TestClear(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	cachedImages(t, 2)
+
+	// Act
+	r, err := Clear()
+	stats, statsErr := GetStats()
+
+	// Assert
+	if err != nil || statsErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", err, statsErr)
+	}
+	if r.Count != 2 || stats.Thumbnails != 0 || stats.Entries != 0 {
+		t.Errorf("Expected 2 removed and an empty cache, got %d removed, %+v", r.Count, stats)
+	}
+}
+
+// TestRemoveKeepsSharedMeta verifies that removing one width of a thumbnail
+// keeps the metadata the other widths still use.
+func TestRemoveKeepsSharedMeta(t *testing.T) {
+	// Arrange: a corrupt large thumbnail next to an intact default one
+	useTempCache(t)
+	paths := cachedImages(t, 1)
+	if _, err := GetThumbnail(paths[0], 2*DefaultWidth, false); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := os.Truncate(thumbFilePath(paths[0], 2*DefaultWidth), 64); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	orphaned, corrupt, err := Verify()
+	_, found := GetMeta(paths[0])
+
+	// Assert
+	if err != nil || orphaned.Count != 0 || corrupt.Count != 1 {
+		t.Fatalf("Expected only the corrupt thumbnail to be removed, got %d orphaned, %d corrupt, %v", orphaned.Count, corrupt.Count, err)
+	}
+	if !found {
+		t.Errorf("Expected the metadata to be kept for the remaining thumbnail")
+	}
+}
@@ -0,0 +1,419 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"waller/internal/palette"
+	"waller/internal/phash"
+	"waller/internal/vfs"
+)
+
+// paletteSize is the number of dominant colors stored per image.
+const paletteSize = 5
+
+// Meta holds data derived from an image while its thumbnail is generated.
+type Meta struct {
+	Palette []palette.Color `json:"palette,omitempty"`
+	// PHash is the perceptual hash of the image as 16 hex digits.
+	PHash string `json:"phash,omitempty"`
+	// Size and ModTime describe the source image the thumbnail was made from.
+	// Entries written before they existed are regenerated once.
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	// Path is the source image, so thumbnails of deleted images can be found.
+	Path string `json:"path,omitempty"`
+	// LastUsed is when the thumbnail was last shown, for least-recently-used eviction.
+	LastUsed time.Time `json:"used"`
+}
+
+// describes reports whether m was computed from the file version described by info.
+func (m Meta) describes(info os.FileInfo) bool {
+	return m.Size == info.Size() && m.ModTime.Equal(info.ModTime())
+}
+
+// Complete reports whether every field has been computed; entries written by
+// older versions may lack newer fields.
+func (m Meta) Complete() bool {
+	return len(m.Palette) > 0 && m.PHash != ""
+}
+
+// Hash returns the perceptual hash as a number.
+func (m Meta) Hash() uint64 {
+	h, _ := strconv.ParseUint(m.PHash, 16, 64)
+	return h
+}
+
+// The metadata store maps thumbnail keys to Meta. It is loaded on first use
+// and written back by SaveMeta, which merges it with what other processes
+// saved meanwhile. metaRemoved holds the keys deleted since the last save, so
+// the merge does not bring them back, and metaCleared that all were.
+var (
+	metaMu      sync.Mutex
+	metaStore   map[string]Meta
+	metaLoaded  bool
+	metaDirty   bool
+	metaRemoved map[string]bool
+	metaCleared bool
+	// metaStamp is the modification time of the file when last read or written.
+	metaStamp time.Time
+)
+
+// metaPath returns the location of the metadata store.
+func metaPath() string {
+	return filepath.Join(thumbDir, "meta.json")
+}
+
+// loadMeta reads the metadata store from disk. The caller must hold metaMu.
+func loadMeta() {
+	if metaLoaded {
+		return
+	}
+	metaLoaded = true
+	metaStore, metaStamp = make(map[string]Meta), time.Time{}
+	reloadMeta()
+}
+
+// reloadMeta merges the metadata file into the store if another process saved
+// it since it was last read, and reports whether it did. The caller must hold metaMu.
+func reloadMeta() bool {
+	info, err := os.Stat(metaPath())
+	if err != nil || info.ModTime().Equal(metaStamp) {
+		return false
+	}
+	data, err := os.ReadFile(metaPath())
+	if err != nil {
+		return false
+	}
+	metaStamp = info.ModTime()
+	// A corrupt store only costs recomputation, so errors are ignored
+	var saved map[string]Meta
+	if json.Unmarshal(data, &saved) != nil || metaCleared {
+		return false
+	}
+	mergeMeta(metaStore, saved, metaRemoved)
+	return true
+}
+
+// getMeta returns the metadata stored under key. Entries another process saved
+// since the store was loaded are found too.
+func getMeta(key string) (Meta, bool) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	loadMeta()
+	m, ok := metaStore[key]
+	if !ok && reloadMeta() {
+		m, ok = metaStore[key]
+	}
+	return m, ok
+}
+
+// setMeta stores m under key.
+func setMeta(key string, m Meta) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	loadMeta()
+	metaStore[key] = m
+	delete(metaRemoved, key)
+	metaDirty = true
+}
+
+// deleteMeta deletes the metadata stored under key. The caller must hold metaMu.
+func deleteMeta(key string) {
+	delete(metaStore, key)
+	if metaRemoved == nil {
+		metaRemoved = make(map[string]bool)
+	}
+	metaRemoved[key] = true
+	metaDirty = true
+}
+
+// mergeMeta adds the entries of src to dst, except the removed keys. Of two
+// entries for one key the one describing the newer image wins, and the later
+// use is kept.
+func mergeMeta(dst, src map[string]Meta, removed map[string]bool) {
+	for key, m := range src {
+		if removed[key] {
+			continue
+		}
+		if cur, ok := dst[key]; ok {
+			used := latest(m.LastUsed, cur.LastUsed)
+			newer := m.ModTime.After(cur.ModTime) || (m.ModTime.Equal(cur.ModTime) && m.Complete() && !cur.Complete())
+			if !newer {
+				m = cur
+			}
+			m.LastUsed = used
+		}
+		dst[key] = m
+	}
+}
+
+// latest returns the later of a and b.
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// useResolution is how stale LastUsed may get before a hit updates it,
+// so browsing does not rewrite the store on every thumbnail shown.
+const useResolution = time.Hour
+
+// touch records that the thumbnail stored under key was just used.
+func touch(key string) {
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	loadMeta()
+	m, ok := metaStore[key]
+	if !ok || time.Since(m.LastUsed) < useResolution {
+		return
+	}
+	m.LastUsed = time.Now()
+	metaStore[key] = m
+	metaDirty = true
+}
+
+// analyze computes the metadata of a thumbnail image.
+func analyze(thumb image.Image) Meta {
+	return Meta{
+		Palette: palette.Extract(thumb, paletteSize),
+		PHash:   fmt.Sprintf("%016x", phash.DHash(thumb)),
+	}
+}
+
+// GetMeta returns the stored metadata for the image at originalPath.
+// It does not touch the image, so the data may describe an older version of it
+// until the thumbnail is regenerated; Analyze always returns current data.
+func GetMeta(originalPath string) (Meta, bool) {
+	initThumbDir()
+	if thumbDirErr != nil {
+		return Meta{}, false
+	}
+	return getMeta(thumbKey(originalPath))
+}
+
+// Analyze returns the complete metadata of the image at originalPath.
+// Missing data is computed from the thumbnail, which is generated if needed,
+// so thumbnails made before a field existed are filled in without touching the original.
+// Data computed from an older version of the image is recomputed, and
+// current data is returned whichever thumbnail width it was computed from.
+func Analyze(originalPath string) (Meta, error) {
+	if info, err := vfs.Stat(originalPath); err == nil {
+		if m, ok := GetMeta(originalPath); ok && m.Complete() && m.describes(info) {
+			return m, nil
+		}
+	}
+
+	thumb, err := GetThumbnail(originalPath, DefaultWidth, false)
+	if err != nil {
+		return Meta{}, err
+	}
+	stored, _ := GetMeta(originalPath)
+	if stored.Complete() {
+		return stored, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		return Meta{}, err
+	}
+
+	m := analyze(img)
+	stored.Palette, stored.PHash = m.Palette, m.PHash
+	setMeta(thumbKey(originalPath), stored)
+	return stored, nil
+}
+
+// AnalyzeAll analyzes paths in parallel and saves the metadata store afterwards.
+// The result is index-aligned with paths; images that could not be analyzed
+// get a zero Meta, for which Complete reports false.
+func AnalyzeAll(paths []string) []Meta {
+	metas := make([]Meta, len(paths))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				m, err := Analyze(paths[i])
+				if err != nil {
+					slog.Debug("Could not analyze image", "path", paths[i], "error", err)
+					continue
+				}
+				metas[i] = m
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := SaveMeta(); err != nil {
+		slog.Warn("Failed to save thumbnail metadata", "error", err)
+	}
+	return metas
+}
+
+// Duplicates groups paths whose perceptual hashes are within threshold.
+// Hashes are read from the metadata store and computed where missing.
+// Paths naming the same file, such as a symlink and its target, count once.
+func Duplicates(paths []string, threshold int) [][]string {
+	paths = distinctFiles(paths)
+	var hashed []string
+	var hashes []uint64
+	for i, m := range AnalyzeAll(paths) {
+		if m.Complete() {
+			hashed = append(hashed, paths[i])
+			hashes = append(hashes, m.Hash())
+		}
+	}
+	return phash.Group(hashed, hashes, threshold)
+}
+
+// fileID identifies a file independently of the paths that lead to it.
+type fileID struct {
+	dev, ino uint64
+}
+
+// distinctFiles returns paths without those naming a file already listed.
+// Of several paths to one file, the one that is not a symlink is kept, so
+// trashing the others never leaves it dangling.
+func distinctFiles(paths []string) []string {
+	distinct := make([]string, 0, len(paths))
+	seen := make(map[fileID]int)
+	for _, path := range paths {
+		var st *syscall.Stat_t
+		if info, err := os.Stat(path); err == nil {
+			st, _ = info.Sys().(*syscall.Stat_t)
+		}
+		if st == nil {
+			distinct = append(distinct, path) // Archive entries and the like
+			continue
+		}
+		id := fileID{uint64(st.Dev), st.Ino}
+		i, dup := seen[id]
+		if !dup {
+			seen[id] = len(distinct)
+			distinct = append(distinct, path)
+			continue
+		}
+		if l, err := os.Lstat(path); err == nil && l.Mode()&os.ModeSymlink == 0 {
+			distinct[i] = path
+		}
+	}
+	return distinct
+}
+
+// SaveMeta writes metadata collected since the last save to disk.
+func SaveMeta() error {
+	initThumbDir()
+	if thumbDirErr != nil {
+		return thumbDirErr
+	}
+
+	metaMu.Lock()
+	if !metaDirty {
+		metaMu.Unlock()
+		return nil
+	}
+	store := maps.Clone(metaStore)
+	removed, cleared := metaRemoved, metaCleared
+	// Changes made while writing mark the store dirty again
+	metaDirty, metaRemoved, metaCleared = false, nil, false
+	metaMu.Unlock()
+
+	stamp, err := writeMeta(store, removed, cleared)
+	metaMu.Lock()
+	defer metaMu.Unlock()
+	if err != nil {
+		metaDirty = true
+		metaCleared = metaCleared || cleared
+		for key := range removed {
+			if _, ok := metaStore[key]; !ok {
+				deleteMeta(key)
+			}
+		}
+		return err
+	}
+	// Take in what other processes saved, unless the store was cleared since
+	if !metaCleared {
+		mergeMeta(metaStore, store, metaRemoved)
+	}
+	metaStamp = stamp
+	return nil
+}
+
+// lockMeta takes the lock that processes saving the metadata store share and
+// returns the function releasing it.
+func lockMeta() (func(), error) {
+	f, err := os.OpenFile(filepath.Join(thumbDir, "meta.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock metadata: %w", err)
+	}
+	return func() { f.Close() }, nil // Closing releases the lock
+}
+
+// writeMeta replaces the metadata file with store, merged with the entries
+// other processes saved since this one loaded it except the removed keys, or
+// all of them if cleared is set. The merged entries are left in store. It
+// returns the modification time of the new file.
+func writeMeta(store map[string]Meta, removed map[string]bool, cleared bool) (time.Time, error) {
+	unlock, err := lockMeta()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+
+	if !cleared {
+		var saved map[string]Meta
+		if data, err := os.ReadFile(metaPath()); err == nil && json.Unmarshal(data, &saved) == nil {
+			mergeMeta(store, saved, removed)
+		}
+	}
+	data, err := json.Marshal(store)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Each save writes its own temporary file, so concurrent saves never mix
+	tmp, err := os.CreateTemp(thumbDir, "meta-*.tmp")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), metaPath())
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(metaPath())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,438 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The pack file starts with packMagic, followed by records that each hold a
+// fixed-size header and the JPEG data of one thumbnail:
+//
+//	key      [32]byte  hex MD5 of the image path
+//	width    uint32
+//	length   uint32    of the data; 0 records a removal
+//	checksum uint32    CRC-32 (IEEE) of the data
+//	written  int64     Unix time in nanoseconds
+//
+// Records are only ever appended, so a later record of the same key and width
+// replaces an earlier one. The index is rebuilt by walking the headers when the
+// pack is opened, and a record cut short by a crash is dropped then. Removed
+// and replaced records take up space until the pack is compacted.
+const (
+	packName       = "thumbnails.pack"
+	packMagic      = "WLRPACK1"
+	packKeyLen     = 32
+	packHeaderSize = packKeyLen + 4 + 4 + 4 + 8
+)
+
+// mapChunk is the step in which the memory mapping grows, so appending does
+// not remap the pack every time.
+const mapChunk = 64 << 20
+
+// packKey addresses a thumbnail in the pack.
+type packKey struct {
+	key   string
+	width int
+}
+
+// packEntry locates the data of a thumbnail in the pack.
+type packEntry struct {
+	off      int64
+	size     uint32
+	checksum uint32
+	written  int64
+}
+
+// packStore keeps thumbnails in one pack file, read through a memory mapping.
+// Other waller processes may append to the same pack; appends are serialized
+// by an advisory lock on the file, and their records are picked up when a
+// thumbnail is missing from the index. A process that compacts the pack
+// replaces the file, and the others switch to the new one when they next lock it.
+type packStore struct {
+	path string
+
+	mu      sync.RWMutex
+	file    *os.File
+	data    []byte // Mapping of the file, possibly longer than it
+	scanned int64  // End of the records in the index
+	index   map[packKey]packEntry
+}
+
+// openPack opens the pack at path, creating it if create is set. It returns
+// nil without an error if the pack does not exist and create is not set.
+func openPack(path string, create bool) (*packStore, error) {
+	flags := os.O_RDWR
+	if create {
+		flags |= os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if !create && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	p := &packStore{path: path, file: f}
+	if err := p.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// load indexes the records of the file, starting a new pack if it is empty
+// or not a pack.
+func (p *packStore) load() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unlock, err := p.lockFile(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	p.index = make(map[packKey]packEntry)
+	p.scanned = int64(len(packMagic))
+
+	header := make([]byte, len(packMagic))
+	if n, _ := p.file.ReadAt(header, 0); n < len(header) || string(header) != packMagic {
+		// A pack this version cannot read only costs regeneration
+		if err := p.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := p.file.WriteAt([]byte(packMagic), 0); err != nil {
+			return err
+		}
+	}
+	return p.catchUp(true)
+}
+
+// lockFile takes the advisory lock on the pack file and returns its release.
+// If another process compacted the pack since it was opened, the file at the
+// path is no longer the open one; it is opened and locked instead, with an
+// empty index for catchUp to fill. The caller must hold p.mu.
+func (p *packStore) lockFile(how int) (func(), error) {
+	for {
+		fd := int(p.file.Fd())
+		if err := syscall.Flock(fd, how); err != nil {
+			return nil, fmt.Errorf("lock %s: %w", p.path, err)
+		}
+		unlock := func() { syscall.Flock(fd, syscall.LOCK_UN) }
+		if !p.replaced() {
+			return unlock, nil
+		}
+		unlock()
+		if err := p.reopen(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// replaced reports whether the pack path now names another file than the
+// open one. A removed pack is not replaced; it is kept in use until closed.
+func (p *packStore) replaced() bool {
+	var open, current syscall.Stat_t
+	if syscall.Fstat(int(p.file.Fd()), &open) != nil || syscall.Stat(p.path, &current) != nil {
+		return false
+	}
+	return open.Dev != current.Dev || open.Ino != current.Ino
+}
+
+// reopen switches to the file at the pack path, forgetting the index of the
+// previous one. The caller must hold p.mu.
+func (p *packStore) reopen() error {
+	f, err := os.OpenFile(p.path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	p.unmap()
+	p.file.Close()
+	p.file = f
+	p.index = make(map[packKey]packEntry)
+	p.scanned = int64(len(packMagic))
+	return nil
+}
+
+// catchUp indexes the records appended to the file since the last scan. With
+// the exclusive lock held, no append is in progress, so an incomplete record
+// at the end was left by a crash and is cut off. The caller must hold p.mu
+// and the file lock.
+func (p *packStore) catchUp(exclusive bool) error {
+	info, err := p.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if size <= p.scanned {
+		return nil
+	}
+	if err := p.remap(size); err != nil {
+		return err
+	}
+
+	off := p.scanned
+	for off+packHeaderSize <= size {
+		h := p.data[off : off+packHeaderSize]
+		k := packKey{string(h[:packKeyLen]), int(binary.LittleEndian.Uint32(h[packKeyLen:]))}
+		e := packEntry{
+			off:      off + packHeaderSize,
+			size:     binary.LittleEndian.Uint32(h[packKeyLen+4:]),
+			checksum: binary.LittleEndian.Uint32(h[packKeyLen+8:]),
+			written:  int64(binary.LittleEndian.Uint64(h[packKeyLen+12:])),
+		}
+		if e.off+int64(e.size) > size {
+			break
+		}
+		if e.size == 0 {
+			delete(p.index, k)
+		} else {
+			p.index[k] = e
+		}
+		off = e.off + int64(e.size)
+	}
+	p.scanned = off
+
+	if exclusive && off < size {
+		return p.file.Truncate(off)
+	}
+	return nil
+}
+
+// remap maps at least the first size bytes of the file.
+func (p *packStore) remap(size int64) error {
+	if size <= int64(len(p.data)) {
+		return nil
+	}
+	if p.data != nil {
+		if err := syscall.Munmap(p.data); err != nil {
+			return err
+		}
+		p.data = nil
+	}
+	// Pages past the end of the file are never read, since the index only
+	// points at complete records
+	length := (size + mapChunk - 1) / mapChunk * mapChunk
+	data, err := syscall.Mmap(int(p.file.Fd()), 0, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("map %s: %w", p.path, err)
+	}
+	p.data = data
+	return nil
+}
+
+func (p *packStore) read(key string, width int) ([]byte, error) {
+	k := packKey{key, width}
+	p.mu.RLock()
+	data, ok := p.lookup(k)
+	p.mu.RUnlock()
+	if ok {
+		return data, nil
+	}
+
+	// Another process may have stored it meanwhile
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unlock, err := p.lockFile(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := p.catchUp(false); err != nil {
+		return nil, err
+	}
+	if data, ok := p.lookup(k); ok {
+		return data, nil
+	}
+	return nil, errMissing
+}
+
+// lookup copies the data of a thumbnail out of the mapping if it is indexed
+// and intact. The caller must hold p.mu.
+func (p *packStore) lookup(k packKey) ([]byte, bool) {
+	e, ok := p.index[k]
+	if !ok {
+		return nil, false
+	}
+	data := p.data[e.off : e.off+int64(e.size)]
+	if crc32.ChecksumIEEE(data) != e.checksum {
+		return nil, false
+	}
+	return bytes.Clone(data), true
+}
+
+func (p *packStore) write(key string, width int, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty thumbnail")
+	}
+	return p.append(packKey{key, width}, data)
+}
+
+func (p *packStore) remove(key string, width int) error {
+	return p.append(packKey{key, width}, nil)
+}
+
+func (p *packStore) removeSizes(key string) {
+	p.mu.RLock()
+	var sizes []packKey
+	for k := range p.index {
+		if k.key == key {
+			sizes = append(sizes, k)
+		}
+	}
+	p.mu.RUnlock()
+	for _, k := range sizes {
+		p.append(k, nil)
+	}
+}
+
+// append adds a record of data for k at the end of the file; nil data removes
+// the thumbnail if it is stored.
+func (p *packStore) append(k packKey, data []byte) error {
+	if len(k.key) != packKeyLen {
+		return fmt.Errorf("invalid thumbnail key %q", k.key)
+	}
+	record := make([]byte, packHeaderSize+len(data))
+	copy(record, k.key)
+	binary.LittleEndian.PutUint32(record[packKeyLen:], uint32(k.width))
+	binary.LittleEndian.PutUint32(record[packKeyLen+4:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[packKeyLen+8:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint64(record[packKeyLen+12:], uint64(time.Now().UnixNano()))
+	copy(record[packHeaderSize:], data)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unlock, err := p.lockFile(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Records of other processes come first, so the end is known
+	if err := p.catchUp(true); err != nil {
+		return err
+	}
+	if _, ok := p.index[k]; !ok && data == nil {
+		return nil
+	}
+	if _, err := p.file.WriteAt(record, p.scanned); err != nil {
+		// Drop what was written, so the next record starts at a header
+		p.file.Truncate(p.scanned)
+		return err
+	}
+	return p.catchUp(true)
+}
+
+func (p *packStore) list() ([]thumbFile, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	unlock, err := p.lockFile(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := p.catchUp(false); err != nil {
+		return nil, err
+	}
+
+	thumbs := make([]thumbFile, 0, len(p.index))
+	for k, e := range p.index {
+		thumbs = append(thumbs, thumbFile{key: k.key, width: k.width, size: int64(e.size), used: time.Unix(0, e.written)})
+	}
+	return thumbs, nil
+}
+
+// compact rewrites the pack without removed and replaced records once they
+// take up more than half of it. Other processes switch to the rewritten file
+// before they next read or append, as lockFile notices the replacement.
+func (p *packStore) compact() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rewritten, err := p.rewrite(); err != nil || !rewritten {
+		return err
+	}
+	if err := p.reopen(); err != nil {
+		return err
+	}
+
+	unlock, err := p.lockFile(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return p.catchUp(false)
+}
+
+// rewrite replaces the pack file with one holding only the indexed records,
+// if that frees more than half of it. The caller must hold p.mu.
+func (p *packStore) rewrite() (bool, error) {
+	unlock, err := p.lockFile(syscall.LOCK_EX)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if err := p.catchUp(true); err != nil {
+		return false, err
+	}
+
+	live := int64(len(packMagic))
+	entries := make([]packEntry, 0, len(p.index))
+	for _, e := range p.index {
+		live += packHeaderSize + int64(e.size)
+		entries = append(entries, e)
+	}
+	if live*2 > p.scanned {
+		return false, nil
+	}
+
+	// Keep the records in their order, oldest first
+	slices.SortFunc(entries, func(a, b packEntry) int {
+		return cmp.Compare(a.off, b.off)
+	})
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), "pack-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(packMagic)
+	for _, e := range entries {
+		w.Write(p.data[e.off-packHeaderSize : e.off+int64(e.size)])
+	}
+	err = w.Flush()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), p.path)
+}
+
+// unmap releases the mapping. The caller must hold p.mu.
+func (p *packStore) unmap() {
+	if p.data != nil {
+		syscall.Munmap(p.data)
+		p.data = nil
+	}
+}
+
+func (p *packStore) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unmap()
+	return p.file.Close()
+}
@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testKey returns a pack key made of c.
+func testKey(c string) string {
+	return strings.Repeat(c, packKeyLen)
+}
+
+// openTestPack opens the pack at path and closes it when the test ends.
+func openTestPack(t *testing.T, path string) *packStore {
+	t.Helper()
+	p, err := openPack(path, true)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { p.close() })
+	return p
+}
+
+// TestPackStore verifies that stored thumbnails survive reopening, that later
+// records replace earlier ones, and that removals and a torn record are honored.
+func TestPackStore(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), packName)
+	p := openTestPack(t, path)
+	p.write(testKey("a"), 200, []byte("first"))
+	p.write(testKey("a"), 200, []byte("second"))
+	p.write(testKey("a"), 300, []byte("wide"))
+	p.write(testKey("b"), 200, []byte("removed"))
+	p.remove(testKey("b"), 200)
+	p.close()
+
+	// Simulate a crash in the middle of an append
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte(testKey("c")))
+	f.Close()
+
+	// Act
+	reopened := openTestPack(t, path)
+	a, aErr := reopened.read(testKey("a"), 200)
+	wide, wideErr := reopened.read(testKey("a"), 300)
+	_, bErr := reopened.read(testKey("b"), 200)
+	thumbs, _ := reopened.list()
+
+	// Assert
+	if aErr != nil || string(a) != "second" {
+		t.Errorf("Expected the latest record, got %q, %v", a, aErr)
+	}
+	if wideErr != nil || string(wide) != "wide" {
+		t.Errorf("Expected the 300px thumbnail, got %q, %v", wide, wideErr)
+	}
+	if bErr == nil {
+		t.Errorf("Expected the removed thumbnail to be missing")
+	}
+	if len(thumbs) != 2 {
+		t.Errorf("Expected 2 stored thumbnails, got %d", len(thumbs))
+	}
+	if info, _ := os.Stat(path); info.Size() != reopened.scanned {
+		t.Errorf("Expected the torn record to be cut off, size %d, records end at %d", info.Size(), reopened.scanned)
+	}
+}
+
+// TestPackCorruption verifies that a record whose data was damaged is not served.
+func TestPackCorruption(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), packName)
+	p := openTestPack(t, path)
+	p.write(testKey("a"), 200, []byte("thumbnail"))
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0644)
+	f.WriteAt([]byte("X"), int64(len(packMagic)+packHeaderSize))
+	f.Close()
+
+	// Act
+	_, err := p.read(testKey("a"), 200)
+
+	// Assert
+	if err == nil {
+		t.Errorf("Expected the damaged thumbnail to be reported missing")
+	}
+}
+
+// TestPackSharedFile verifies that thumbnails appended by another process are
+// found and that concurrent appends do not overwrite each other.
+func TestPackSharedFile(t *testing.T) {
+	// Arrange: two stores on one file, as in the GUI and a CLI command
+	path := filepath.Join(t.TempDir(), packName)
+	first := openTestPack(t, path)
+	second := openTestPack(t, path)
+
+	// Act
+	var wg sync.WaitGroup
+	for i, p := range []*packStore{first, second} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range 50 {
+				p.write(testKey(string(rune('a'+i))), w+1, bytes.Repeat([]byte{byte(i)}, 100+w))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	for i, p := range []*packStore{second, first} {
+		other := 1 - i
+		for w := range 50 {
+			data, err := p.read(testKey(string(rune('a'+other))), w+1)
+			if err != nil || len(data) != 100+w || data[0] != byte(other) {
+				t.Fatalf("Expected thumbnail %d of store %d, got %d bytes, %v", w+1, other, len(data), err)
+			}
+		}
+	}
+}
+
+// TestPackCompaction verifies that compacting frees the space of removed
+// thumbnails and keeps the others.
+func TestPackCompaction(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), packName)
+	p := openTestPack(t, path)
+	for w := range 10 {
+		p.write(testKey("a"), w+1, bytes.Repeat([]byte{1}, 1000))
+	}
+	for w := range 9 {
+		p.remove(testKey("a"), w+1)
+	}
+	before, _ := os.Stat(path)
+
+	// Act
+	err := p.compact()
+	after, _ := os.Stat(path)
+	data, readErr := p.read(testKey("a"), 10)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after.Size() >= before.Size()/4 {
+		t.Errorf("Expected the pack to shrink, got %d bytes from %d", after.Size(), before.Size())
+	}
+	if readErr != nil || len(data) != 1000 {
+		t.Errorf("Expected the kept thumbnail to be readable, got %d bytes, %v", len(data), readErr)
+	}
+}
+
+// TestPackCompactionShared verifies that a store sharing the pack with the one
+// that compacts it switches to the compacted file instead of losing its writes.
+func TestPackCompactionShared(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), packName)
+	compacting := openTestPack(t, path)
+	other := openTestPack(t, path)
+	for w := range 10 {
+		compacting.write(testKey("a"), w+1, bytes.Repeat([]byte{1}, 1000))
+	}
+	for w := range 9 {
+		compacting.remove(testKey("a"), w+1)
+	}
+
+	// Act
+	compactErr := compacting.compact()
+	writeErr := other.write(testKey("b"), 200, []byte("after compaction"))
+	kept, keptErr := other.read(testKey("a"), 10)
+	added, addedErr := compacting.read(testKey("b"), 200)
+
+	// Assert
+	if compactErr != nil || writeErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", compactErr, writeErr)
+	}
+	if keptErr != nil || len(kept) != 1000 {
+		t.Errorf("Expected the other store to read the compacted pack, got %d bytes, %v", len(kept), keptErr)
+	}
+	if addedErr != nil || string(added) != "after compaction" {
+		t.Errorf("Expected the write after compaction to reach the pack, got %q, %v", added, addedErr)
+	}
+}
+
+// TestLayoutMigration verifies that thumbnails move into the pack when it is
+// chosen and back into files when it is not.
+func TestLayoutMigration(t *testing.T) {
+	// Arrange: thumbnails stored as files
+	useTempCache(t)
+	t.Cleanup(func() { UseLayout(Files) })
+	paths := cachedImages(t, 2)
+	want, _ := GetThumbnail(paths[0], 0, true)
+	SaveMeta()
+
+	// Act
+	reopen := func(l Layout) {
+		thumbStore.close()
+		thumbDirOnce = sync.Once{}
+		UseLayout(l)
+		initThumbDir()
+	}
+	reopen(Pack)
+	packed, packedErr := GetThumbnail(paths[0], 0, true)
+	files, _ := filepath.Glob(filepath.Join(thumbDir, "*.jpg"))
+	reopen(Files)
+	unpacked, unpackedErr := GetThumbnail(paths[0], 0, true)
+	_, packErr := os.Stat(filepath.Join(thumbDir, packName))
+
+	// Assert
+	if packedErr != nil || !bytes.Equal(packed, want) {
+		t.Errorf("Expected the thumbnail from the pack, got %v", packedErr)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no thumbnail files next to the pack, got %v", files)
+	}
+	if unpackedErr != nil || !bytes.Equal(unpacked, want) {
+		t.Errorf("Expected the thumbnail back in a file, got %v", unpackedErr)
+	}
+	if !os.IsNotExist(packErr) {
+		t.Errorf("Expected the emptied pack to be removed, got %v", packErr)
+	}
+}
+
+// TestPackThumbnails verifies thumbnail generation and maintenance with the pack layout.
+func TestPackThumbnails(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	UseLayout(Pack)
+	t.Cleanup(func() { UseLayout(Files) })
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wall.png")
+	writePNG(t, path, 300, 200, color.RGBA{255, 0, 0, 255})
+
+	// Act
+	thumb, err := GetThumbnail(path, 0, false)
+	_, fastErr := GetThumbnail(path, 0, true)
+	os.Remove(path)
+	orphaned, _, verifyErr := Verify()
+	stats, _ := GetStats()
+
+	// Assert
+	if err != nil || fastErr != nil || imageWidth(t, thumb) != DefaultWidth {
+		t.Fatalf("Expected a packed thumbnail, got %v, %v", err, fastErr)
+	}
+	if verifyErr != nil || orphaned.Count != 1 || stats.Thumbnails != 0 {
+		t.Errorf("Expected the orphan to be removed, got %d removed, %d left, %v", orphaned.Count, stats.Thumbnails, verifyErr)
+	}
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Layout is how thumbnails are stored in the cache directory.
+type Layout string
+
+const (
+	// Files stores every thumbnail as a JPEG file of its own. This is the default.
+	Files Layout = "files"
+	// Pack appends thumbnails to a single indexed file that is read through a
+	// memory mapping, which saves a file lookup per thumbnail in large libraries.
+	Pack Layout = "pack"
+)
+
+// Layouts lists the thumbnail layouts.
+var Layouts = []Layout{Files, Pack}
+
+// ParseLayout returns the layout named s, or Files if s names none.
+func ParseLayout(s string) Layout {
+	if l := Layout(s); slices.Contains(Layouts, l) {
+		return l
+	}
+	return Files
+}
+
+// layout is the layout the store is opened with (set before first use).
+var layout = Files
+
+// UseLayout selects how thumbnails are stored. It must be called before the
+// cache is first used. Thumbnails stored in the other layout are moved over
+// when the cache is opened, so switching keeps them.
+func UseLayout(l Layout) {
+	layout = l
+}
+
+// errMissing reports a thumbnail that is not stored or is incomplete.
+var errMissing = errors.New("thumbnail not stored")
+
+// store keeps the encoded thumbnails of the cache, each addressed by the key
+// of its image and its width.
+type store interface {
+	// read returns the complete JPEG data of a thumbnail, or errMissing.
+	read(key string, width int) ([]byte, error)
+	// write stores data so that readers see either all of it or the previous version.
+	write(key string, width int, data []byte) error
+	// remove deletes one thumbnail; removing a missing one is not an error.
+	remove(key string, width int) error
+	// removeSizes deletes the thumbnails of every width stored under key.
+	removeSizes(key string)
+	// list returns every stored thumbnail, with its write time as last use.
+	list() ([]thumbFile, error)
+	// compact reclaims the space of removed thumbnails.
+	compact() error
+	close() error
+}
+
+// thumbStore holds the thumbnails; it is opened by initThumbDir.
+var thumbStore store
+
+// openStore opens the store of the chosen layout in thumbDir and moves any
+// thumbnails left in the other layout into it.
+func openStore() (store, error) {
+	files := &dirStore{dir: thumbDir}
+	if layout != Pack {
+		pack, err := openPack(filepath.Join(thumbDir, packName), false)
+		if err == nil && pack != nil {
+			migrate(pack, files)
+			pack.close()
+			os.Remove(pack.path)
+		}
+		return files, nil
+	}
+
+	pack, err := openPack(filepath.Join(thumbDir, packName), true)
+	if err != nil {
+		return nil, err
+	}
+	migrate(files, pack)
+	return pack, nil
+}
+
+// migrate moves every thumbnail stored in from into to.
+func migrate(from, to store) {
+	thumbs, err := from.list()
+	if err != nil || len(thumbs) == 0 {
+		return
+	}
+	slog.Info("Moving thumbnails to the new cache layout", "count", len(thumbs), "layout", layout)
+	for _, t := range thumbs {
+		data, err := from.read(t.key, t.width)
+		if err == nil {
+			err = to.write(t.key, t.width, data)
+		}
+		if err != nil && !errors.Is(err, errMissing) {
+			slog.Warn("Could not move thumbnail", "key", t.key, "error", err)
+			continue
+		}
+		from.remove(t.key, t.width)
+	}
+}
+
+// dirStore keeps each thumbnail in a JPEG file named after its key and width.
+type dirStore struct {
+	dir string
+}
+
+// path returns where the thumbnail of the given width is stored under key.
+// Thumbnails of the default width keep the name they had before sizes were configurable.
+func (s *dirStore) path(key string, width int) string {
+	if width == DefaultWidth {
+		return filepath.Join(s.dir, key+".jpg")
+	}
+	return filepath.Join(s.dir, key+"-"+strconv.Itoa(width)+".jpg")
+}
+
+func (s *dirStore) read(key string, width int) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key, width))
+	if err != nil || !intact(data) {
+		return nil, errMissing
+	}
+	return data, nil
+}
+
+func (s *dirStore) write(key string, width int, data []byte) error {
+	// Write to a temporary file first so no reader ever sees a partial thumbnail
+	tmp, err := os.CreateTemp(s.dir, key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(key, width))
+}
+
+func (s *dirStore) remove(key string, width int) error {
+	if err := os.Remove(s.path(key, width)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *dirStore) removeSizes(key string) {
+	sized, _ := filepath.Glob(filepath.Join(s.dir, key+"-*.jpg"))
+	for _, p := range append(sized, s.path(key, DefaultWidth)) {
+		os.Remove(p)
+	}
+}
+
+func (s *dirStore) list() ([]thumbFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var thumbs []thumbFile
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".jpg")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		// Thumbnails of other widths end in "-<width>"
+		t := thumbFile{key: name, width: DefaultWidth}
+		if key, width, ok := strings.Cut(name, "-"); ok {
+			w, err := strconv.Atoi(width)
+			if err != nil {
+				continue
+			}
+			t.key, t.width = key, w
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed meanwhile
+		}
+		t.size, t.used = info.Size(), info.ModTime()
+		thumbs = append(thumbs, t)
+	}
+	return thumbs, nil
+}
+
+func (s *dirStore) compact() error { return nil }
+
+func (s *dirStore) close() error { return nil }
+
+// intact reports whether data is a JPEG file that ends with the end-of-image
+// marker, which a file cut short by a crash or a full disk lacks.
+func intact(data []byte) bool {
+	return len(data) >= 2 && data[len(data)-2] == 0xFF && data[len(data)-1] == 0xD9
+}
@@ -1,17 +1,155 @@
-// Package config manages application configuration including wallpaper directory settings.
+// Package config manages application configuration including wallpaper library settings.
 // Configuration is stored as JSON in the user's config directory.
 package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 )
 
+// Library is a named wallpaper directory that can be toggled on or off.
+type Library struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+}
+
 // Config holds the application settings that are saved to disk.
 type Config struct {
-	// WallpaperDir is the path where the user stores their wallpapers.
-	WallpaperDir string `json:"wallpaper_dir"`
+	// Libraries are the directories the user stores their wallpapers in.
+	Libraries []Library `json:"libraries"`
+	// WallpaperDir is the legacy single-directory setting.
+	// It is migrated into Libraries on load and no longer written.
+	WallpaperDir string `json:"wallpaper_dir,omitempty"`
+	// MaxDepth limits how many directory levels are scanned (1 = top level only, 0 = unlimited).
+	MaxDepth int `json:"max_depth,omitempty"`
+	// FollowSymlinks makes scanning descend into symlinked directories.
+	FollowSymlinks bool `json:"follow_symlinks,omitempty"`
+	// SkipVerify trusts file extensions instead of sniffing image content during scans.
+	SkipVerify bool `json:"skip_verify,omitempty"`
+	// Include and Exclude filter scanned files by glob or, with a "re:" prefix, regex.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// ShowHidden includes dot files and directories in scans.
+	ShowHidden bool `json:"show_hidden,omitempty"`
+	// AnimationFPS caps the frame rate of animated wallpapers (0 = default).
+	AnimationFPS int `json:"animation_fps,omitempty"`
+	// Providers are the online galleries shown as the "Online" source in the GUI.
+	// Each entry is decoded by the provider package.
+	Providers []json.RawMessage `json:"providers,omitempty"`
+	// SortBy and SortDescending are the grid order chosen in the GUI.
+	SortBy         string `json:"sort_by,omitempty"`
+	SortDescending bool   `json:"sort_descending,omitempty"`
+	// ThumbnailSizes are the zoom levels of the GUI grid, as thumbnail widths in
+	// logical pixels; ThumbnailSize is the one last chosen.
+	ThumbnailSizes []int `json:"thumbnail_sizes,omitempty"`
+	ThumbnailSize  int   `json:"thumbnail_size,omitempty"`
+	// ThumbnailCacheMaxMB and ThumbnailCacheMaxDays bound the thumbnail cache
+	// by total size and by time since a thumbnail was last shown (0 = unlimited).
+	ThumbnailCacheMaxMB   int `json:"thumbnail_cache_max_mb,omitempty"`
+	ThumbnailCacheMaxDays int `json:"thumbnail_cache_max_days,omitempty"`
+	// ShareThumbnails also writes generated thumbnails to the freedesktop.org
+	// thumbnail cache used by file managers.
+	ShareThumbnails bool `json:"share_thumbnails,omitempty"`
+	// ThumbnailStore is how thumbnails are stored: "files" (default) or "pack",
+	// a single file that suits libraries of tens of thousands of images.
+	ThumbnailStore string `json:"thumbnail_store,omitempty"`
+	// FitMode is how the daemon fits wallpapers to monitors: "cover" (default),
+	// "contain", "stretch" or "center".
+	FitMode string `json:"fit_mode,omitempty"`
+}
+
+// defaultThumbnailSizes are the grid zoom levels used unless configured.
+var defaultThumbnailSizes = []int{150, 225, 300}
+
+// ZoomLevels returns the configured grid thumbnail widths in ascending order.
+func (c *Config) ZoomLevels() []int {
+	var sizes []int
+	for _, size := range c.ThumbnailSizes {
+		if size > 0 && !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		return slices.Clone(defaultThumbnailSizes)
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// Zoom returns the chosen grid thumbnail width, or the smallest zoom level if
+// none was chosen or it is no longer configured.
+func (c *Config) Zoom() int {
+	levels := c.ZoomLevels()
+	if slices.Contains(levels, c.ThumbnailSize) {
+		return c.ThumbnailSize
+	}
+	return levels[0]
+}
+
+// EnabledLibraries returns the libraries that are switched on.
+func (c *Config) EnabledLibraries() []Library {
+	libs := make([]Library, 0, len(c.Libraries))
+	for _, lib := range c.Libraries {
+		if lib.Enabled {
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
+
+// EnabledDirs returns the paths of all enabled libraries.
+func (c *Config) EnabledDirs() []string {
+	libs := c.EnabledLibraries()
+	dirs := make([]string, len(libs))
+	for i, lib := range libs {
+		dirs[i] = lib.Path
+	}
+	return dirs
+}
+
+// FindLibrary returns the library with the given name, or nil if there is none.
+func (c *Config) FindLibrary(name string) *Library {
+	for i := range c.Libraries {
+		if c.Libraries[i].Name == name {
+			return &c.Libraries[i]
+		}
+	}
+	return nil
+}
+
+// AddLibrary registers dir as an enabled library and returns it.
+// If dir is already a library it is re-enabled instead of added twice.
+// New libraries are named after the directory, with a numeric suffix on clashes.
+func (c *Config) AddLibrary(dir string) Library {
+	for i := range c.Libraries {
+		if c.Libraries[i].Path == dir {
+			c.Libraries[i].Enabled = true
+			return c.Libraries[i]
+		}
+	}
+
+	base := filepath.Base(dir)
+	name := base
+	for n := 2; c.FindLibrary(name) != nil; n++ {
+		name = fmt.Sprintf("%s (%d)", base, n)
+	}
+
+	lib := Library{Name: name, Path: dir, Enabled: true}
+	c.Libraries = append(c.Libraries, lib)
+	return lib
+}
+
+// migrate moves the legacy WallpaperDir setting into Libraries.
+func (c *Config) migrate() {
+	if c.WallpaperDir == "" {
+		return
+	}
+	c.AddLibrary(c.WallpaperDir)
+	c.WallpaperDir = ""
 }
 
 func GetConfigPath() (string, error) {
@@ -33,7 +171,7 @@ func Load() (*Config, error) {
 	// Check if the file exists. If not, return a default configuration.
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return &Config{
-			WallpaperDir: "", // User will set this in the GUI
+			Libraries: nil, // User will add libraries in the GUI
 		}, nil
 	}
 
@@ -48,6 +186,7 @@ func Load() (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	cfg.migrate()
 
 	return &cfg, nil
 }
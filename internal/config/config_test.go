@@ -1,6 +1,7 @@
 package config
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -28,3 +29,80 @@ func TestLoadConfig(t *testing.T) {
 		t.Logf("Note: WallpaperDir isn't exactly matched, got %v", cfg.WallpaperDir)
 	}
 }
+
+// TestLegacyWallpaperDirMigration verifies that a single wallpaper_dir becomes a library.
+func TestLegacyWallpaperDirMigration(t *testing.T) {
+	// Arrange: Write a config in the old single-directory format
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpHome, ".config"))
+
+	path, err := GetConfigPath()
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, []byte(`{"wallpaper_dir": "/srv/walls"}`), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.WallpaperDir != "" {
+		t.Errorf("Expected legacy WallpaperDir to be cleared, got %q", cfg.WallpaperDir)
+	}
+	if len(cfg.Libraries) != 1 || cfg.Libraries[0].Path != "/srv/walls" || !cfg.Libraries[0].Enabled {
+		t.Errorf("Expected one enabled library for /srv/walls, got %+v", cfg.Libraries)
+	}
+}
+
+// TestAddLibrary verifies naming, de-duplication and the enabled filter.
+func TestAddLibrary(t *testing.T) {
+	// Arrange
+	cfg := &Config{}
+
+	// Act
+	cfg.AddLibrary("/home/me/walls")
+	cfg.AddLibrary("/mnt/nas/walls")
+	cfg.AddLibrary("/home/me/walls")
+	cfg.Libraries[1].Enabled = false
+
+	// Assert
+	if len(cfg.Libraries) != 2 {
+		t.Fatalf("Expected 2 libraries, got %d", len(cfg.Libraries))
+	}
+	if cfg.Libraries[1].Name != "walls (2)" {
+		t.Errorf("Expected clashing name to be suffixed, got %q", cfg.Libraries[1].Name)
+	}
+	if dirs := cfg.EnabledDirs(); len(dirs) != 1 || dirs[0] != "/home/me/walls" {
+		t.Errorf("Expected only the enabled library, got %v", dirs)
+	}
+}
+
+// TestZoomLevels verifies that configured thumbnail sizes are cleaned up and
+// that an unknown chosen size falls back to the smallest level.
+func TestZoomLevels(t *testing.T) {
+	// Arrange
+	defaults := &Config{}
+	custom := &Config{ThumbnailSizes: []int{400, 0, 200, 400}, ThumbnailSize: 300}
+	chosen := &Config{ThumbnailSizes: []int{200, 400}, ThumbnailSize: 400}
+
+	// Act & Assert
+	if got := defaults.ZoomLevels(); len(got) != 3 || got[0] != 150 || defaults.Zoom() != 150 {
+		t.Errorf("Expected the default levels starting at 150, got %v and zoom %d", got, defaults.Zoom())
+	}
+	if got := custom.ZoomLevels(); len(got) != 2 || got[0] != 200 || got[1] != 400 {
+		t.Errorf("Expected levels [200 400], got %v", got)
+	}
+	if got := custom.Zoom(); got != 200 {
+		t.Errorf("Expected an unconfigured size to fall back to 200, got %d", got)
+	}
+	if got := chosen.Zoom(); got != 400 {
+		t.Errorf("Expected the chosen size 400, got %d", got)
+	}
+}
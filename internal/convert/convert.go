@@ -0,0 +1,143 @@
+// Package convert turns images the daemon cannot render natively (AVIF, JPEG XL)
+// into PNG files it can. Each image is converted once with an external tool and
+// the result is kept in the waller cache directory until the source changes.
+package convert
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrNoConverter is returned when none of the tools for a format is installed.
+var ErrNoConverter = errors.New("no converter installed")
+
+// converters lists, per extension, the commands tried in order. Each one is
+// called with the source and destination paths appended.
+var converters = map[string][][]string{
+	".avif": {{"avifdec"}, {"heif-convert"}, {"magick"}, {"convert"}},
+	".jxl":  {{"djxl"}, {"magick"}, {"convert"}},
+}
+
+// dir is resolved once at first use, like the thumbnail directory.
+var (
+	dir     string
+	dirOnce sync.Once
+	dirErr  error
+)
+
+// locks serializes conversions of the same file, so parallel thumbnail and
+// index workers do not run the converter twice.
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*sync.Mutex)
+)
+
+func initDir() {
+	dirOnce.Do(func() {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			dirErr = err
+			return
+		}
+		dir = filepath.Join(cacheDir, "waller", "converted")
+		dirErr = os.MkdirAll(dir, 0755)
+	})
+}
+
+// NeedsConversion reports whether path is in a format that has to be converted
+// before it can be decoded or displayed.
+func NeedsConversion(path string) bool {
+	_, ok := converters[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// Renderable returns a path to an image the daemon and the Go decoders can read:
+// path itself for natively supported formats, otherwise a cached PNG conversion.
+func Renderable(path string) (string, error) {
+	commands, ok := converters[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return path, nil
+	}
+
+	initDir()
+	if dirErr != nil {
+		return "", dirErr
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Size and mtime are part of the key so an edited source is converted again
+	sum := md5.Sum([]byte(path + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" + strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+	key := hex.EncodeToString(sum[:])
+	out := filepath.Join(dir, key+".png")
+
+	mu := lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+
+	var tmp string
+	var lastErr error
+	for _, command := range commands {
+		bin, err := exec.LookPath(command[0])
+		if err != nil {
+			continue
+		}
+		if tmp == "" {
+			// Converters pick the output format from the extension, so the temp file
+			// keeps it. Its name is unique, as another process may convert the same file.
+			f, err := os.CreateTemp(dir, key+"-*.tmp.png")
+			if err != nil {
+				return "", err
+			}
+			f.Close()
+			tmp = f.Name()
+			defer os.Remove(tmp)
+		}
+		args := append(command[1:len(command):len(command)], path, tmp)
+		output, err := exec.Command(bin, args...).CombinedOutput()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w: %s", command[0], err, strings.TrimSpace(string(output)))
+			continue
+		}
+		if err := os.Rename(tmp, out); err != nil {
+			return "", err
+		}
+		return out, nil
+	}
+
+	if lastErr != nil {
+		return "", fmt.Errorf("convert %s: %w", path, lastErr)
+	}
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command[0]
+	}
+	return "", fmt.Errorf("convert %s: %w (tried %s)", path, ErrNoConverter, strings.Join(names, ", "))
+}
+
+// lock returns the mutex guarding conversions for key.
+func lock(key string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	mu, ok := locks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[key] = mu
+	}
+	return mu
+}
@@ -0,0 +1,92 @@
+package convert
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRenderableNative verifies natively supported images are used as they are.
+func TestRenderableNative(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "a.tiff")
+
+	// Act
+	got, err := Renderable(path)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != path {
+		t.Errorf("Expected %s unchanged, got %s", path, got)
+	}
+}
+
+// TestRenderableConvertsOnce verifies conversion through an external tool and
+// that the cached result is reused until the source changes.
+func TestRenderableConvertsOnce(t *testing.T) {
+	// Arrange: a fake djxl that copies its input and counts its runs
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(tmpDir, "cache"))
+	bin := filepath.Join(tmpDir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	runs := filepath.Join(tmpDir, "runs")
+	script := "#!/bin/sh\necho run >> " + runs + "\ncp \"$1\" \"$2\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "djxl"), []byte(script), 0755); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	src := filepath.Join(tmpDir, "photo.jxl")
+	if err := os.WriteFile(src, []byte("jxl data"), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	first, err := Renderable(src)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := Renderable(src)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	if first != second || !strings.HasSuffix(first, ".png") {
+		t.Errorf("Expected the same cached PNG twice, got %s and %s", first, second)
+	}
+	data, _ := os.ReadFile(first)
+	if string(data) != "jxl data" {
+		t.Errorf("Expected converted output, got %q", data)
+	}
+	log, _ := os.ReadFile(runs)
+	if n := strings.Count(string(log), "run"); n != 1 {
+		t.Errorf("Expected the converter to run once, ran %d times", n)
+	}
+}
+
+// TestRenderableNoConverter verifies a clear error when no tool is installed.
+func TestRenderableNoConverter(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", tmpDir)
+	t.Setenv("PATH", tmpDir)
+	src := filepath.Join(tmpDir, "photo.avif")
+	if err := os.WriteFile(src, []byte("avif data"), 0644); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	_, err := Renderable(src)
+
+	// Assert
+	if !errors.Is(err, ErrNoConverter) {
+		t.Errorf("Expected ErrNoConverter, got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Package fit decides whether an image suits a monitor's resolution and aspect ratio.
+package fit
+
+import (
+	"math"
+	"slices"
+)
+
+// Monitor is the physical pixel size of a display.
+type Monitor struct {
+	Width  int
+	Height int
+}
+
+// aspectTolerance is the largest relative aspect ratio difference still considered a match.
+// It accepts 16:10 images on 16:9 monitors but rejects 4:3 or portrait images.
+const aspectTolerance = 0.12
+
+// AspectMatches reports whether an image of w×h has roughly the monitor's aspect ratio.
+func AspectMatches(w, h int, mon Monitor) bool {
+	if w <= 0 || h <= 0 || mon.Width <= 0 || mon.Height <= 0 {
+		return false
+	}
+	img := float64(w) / float64(h)
+	screen := float64(mon.Width) / float64(mon.Height)
+	return math.Abs(img-screen)/screen <= aspectTolerance
+}
+
+// Upscaled reports whether covering the monitor with an image of w×h enlarges it.
+func Upscaled(w, h int, mon Monitor) bool {
+	return w < mon.Width || h < mon.Height
+}
+
+// Suits reports whether an image of w×h matches the aspect ratio of every given
+// monitor and covers each of them without upscaling.
+func Suits(w, h int, mons ...Monitor) bool {
+	for _, mon := range mons {
+		if !AspectMatches(w, h, mon) || Upscaled(w, h, mon) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the paths whose images suit all monitors.
+// size looks up an image's pixel dimensions; images of unknown size are left out.
+func Filter(paths []string, size func(path string) (w, h int, ok bool), mons []Monitor) []string {
+	var matched []string
+	for _, path := range paths {
+		w, h, ok := size(path)
+		if ok && Suits(w, h, mons...) {
+			matched = append(matched, path)
+		}
+	}
+	return matched
+}
+
+// Mode is how an image is fitted to a monitor of another size or shape.
+type Mode string
+
+const (
+	// Cover fills the monitor, cropping what overhangs. This is the default.
+	Cover Mode = "cover"
+	// Contain shows the whole image, leaving bars on two sides.
+	Contain Mode = "contain"
+	// Stretch fills the monitor, distorting the aspect ratio.
+	Stretch Mode = "stretch"
+	// Center shows the image unscaled in the middle.
+	Center Mode = "center"
+)
+
+// Modes lists the fit modes.
+var Modes = []Mode{Cover, Contain, Stretch, Center}
+
+// ParseMode returns the fit mode named s, or Cover if s names none.
+func ParseMode(s string) Mode {
+	if m := Mode(s); slices.Contains(Modes, m) {
+		return m
+	}
+	return Cover
+}
+
+// Scaled returns the size an image of w×h is scaled to on mon in mode m.
+// The result may overhang the monitor for Cover and Center.
+func Scaled(w, h int, mon Monitor, m Mode) (int, int) {
+	if w <= 0 || h <= 0 {
+		return w, h
+	}
+	sx := float64(mon.Width) / float64(w)
+	sy := float64(mon.Height) / float64(h)
+	var s float64
+	switch m {
+	case Stretch:
+		return mon.Width, mon.Height
+	case Center:
+		return w, h
+	case Contain:
+		s = math.Min(sx, sy)
+	default:
+		s = math.Max(sx, sy)
+	}
+	return max(int(math.Round(float64(w)*s)), 1), max(int(math.Round(float64(h)*s)), 1)
+}
@@ -0,0 +1,78 @@
+package fit
+
+import "testing"
+
+// TestSuits verifies aspect ratio and resolution matching.
+func TestSuits(t *testing.T) {
+	landscape4K := Monitor{Width: 3840, Height: 2160}
+	portrait4K := Monitor{Width: 2160, Height: 3840}
+
+	tests := []struct {
+		name string
+		w, h int
+		mon  Monitor
+		want bool
+	}{
+		{name: "exact 4K", w: 3840, h: 2160, mon: landscape4K, want: true},
+		{name: "larger 16:10", w: 5120, h: 3200, mon: landscape4K, want: true},
+		{name: "1080p upscaled", w: 1920, h: 1080, mon: landscape4K, want: false},
+		{name: "landscape on portrait", w: 3840, h: 2160, mon: portrait4K, want: false},
+		{name: "4:3", w: 4000, h: 3000, mon: landscape4K, want: false},
+		{name: "unknown size", w: 0, h: 0, mon: landscape4K, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := Suits(tt.w, tt.h, tt.mon); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestFilter verifies that an image must suit every monitor.
+func TestFilter(t *testing.T) {
+	// Arrange
+	sizes := map[string][2]int{
+		"wide.jpg":  {3840, 2160},
+		"small.jpg": {1920, 1080},
+	}
+	size := func(path string) (int, int, bool) {
+		s, ok := sizes[path]
+		return s[0], s[1], ok
+	}
+	paths := []string{"wide.jpg", "small.jpg", "unindexed.jpg"}
+
+	// Act
+	onFHD := Filter(paths, size, []Monitor{{1920, 1080}})
+	onBoth := Filter(paths, size, []Monitor{{1920, 1080}, {3840, 2160}})
+
+	// Assert
+	if len(onFHD) != 2 {
+		t.Errorf("Expected 2 matches on 1080p, got %v", onFHD)
+	}
+	if len(onBoth) != 1 || onBoth[0] != "wide.jpg" {
+		t.Errorf("Expected only wide.jpg to suit both monitors, got %v", onBoth)
+	}
+}
+
+// TestScaled verifies the scaled image size of each fit mode.
+func TestScaled(t *testing.T) {
+	mon := Monitor{Width: 1920, Height: 1080}
+
+	tests := []struct {
+		mode         Mode
+		w, h         int
+		wantW, wantH int
+	}{
+		{mode: Cover, w: 4000, h: 3000, wantW: 1920, wantH: 1440},
+		{mode: Contain, w: 4000, h: 3000, wantW: 1440, wantH: 1080},
+		{mode: Stretch, w: 4000, h: 3000, wantW: 1920, wantH: 1080},
+		{mode: Center, w: 4000, h: 3000, wantW: 4000, wantH: 3000},
+		{mode: Cover, w: 960, h: 540, wantW: 1920, wantH: 1080},
+	}
+
+	for _, tt := range tests {
+		if w, h := Scaled(tt.w, tt.h, mon, tt.mode); w != tt.wantW || h != tt.wantH {
+			t.Errorf("%s %dx%d: expected %dx%d, got %dx%d", tt.mode, tt.w, tt.h, tt.wantW, tt.wantH, w, h)
+		}
+	}
+}
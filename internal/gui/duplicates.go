@@ -0,0 +1,131 @@
+package gui
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+
+	"waller/internal/cache"
+	"waller/internal/phash"
+	"waller/internal/trash"
+)
+
+// showDuplicates opens a window listing groups of near-identical images among files.
+// Each copy has a "Keep" button that moves the other copies of its group to the trash.
+func showDuplicates(parent *gtk.Window, files []string) {
+	win, _ := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	win.SetTitle("Duplicates")
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(700, 500)
+
+	scroll, _ := gtk.ScrolledWindowNew(nil, nil)
+	scroll.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	win.Add(scroll)
+
+	groupsBox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 10)
+	groupsBox.SetMarginTop(10)
+	groupsBox.SetMarginStart(10)
+	scroll.Add(groupsBox)
+
+	status, _ := gtk.LabelNew("Searching for duplicates…")
+	groupsBox.PackStart(status, false, false, 0)
+
+	win.ShowAll()
+
+	go func() {
+		groups := cache.Duplicates(files, phash.DefaultThreshold)
+		for _, group := range groups {
+			if globalIndex != nil {
+				globalIndex.Update(group)
+				globalIndex.SortLargestFirst(group)
+			}
+		}
+
+		glib.IdleAdd(func() bool {
+			if len(groups) == 0 {
+				status.SetText("No duplicates found")
+				return false
+			}
+			status.SetText(fmt.Sprintf("%d groups of duplicates, largest copy first", len(groups)))
+			for _, group := range groups {
+				groupsBox.PackStart(duplicateGroup(win, group), false, false, 0)
+			}
+			return false // Run once
+		})
+	}()
+}
+
+// duplicateGroup builds the row for one group of duplicates.
+func duplicateGroup(win *gtk.Window, group []string) *gtk.Frame {
+	frame, _ := gtk.FrameNew(fmt.Sprintf("%d copies", len(group)))
+	row, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 10)
+	frame.Add(row)
+
+	for _, path := range group {
+		cell, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+		cell.PackStart(thumbnailImage(path), false, false, 0)
+
+		info := filepath.Base(path)
+		if w, h, ok := indexedSize(path); ok {
+			info = fmt.Sprintf("%s\n%d×%d", info, w, h)
+		}
+		lbl, _ := gtk.LabelNew(info)
+		lbl.SetMaxWidthChars(20)
+		cell.PackStart(lbl, false, false, 0)
+		cell.SetTooltipText(path)
+
+		keepBtn, _ := gtk.ButtonNewWithLabel("Keep")
+		keepBtn.Connect("clicked", func() {
+			if keepOnly(win, group, path) {
+				frame.Destroy()
+			}
+		})
+		cell.PackStart(keepBtn, false, false, 0)
+
+		row.PackStart(cell, false, false, 0)
+	}
+
+	frame.ShowAll()
+	return frame
+}
+
+// keepOnly asks for confirmation and moves every path of group except keep to the trash.
+// It reports whether the group was resolved.
+func keepOnly(win *gtk.Window, group []string, keep string) bool {
+	dlg := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_QUESTION, gtk.BUTTONS_OK_CANCEL,
+		"Keep %s and move %d other copies to the trash?", filepath.Base(keep), len(group)-1)
+	resp := dlg.Run()
+	dlg.Destroy()
+	if resp != gtk.RESPONSE_OK {
+		return false
+	}
+
+	kept, _ := os.Stat(keep)
+	failed := 0
+	for _, path := range group {
+		if path == keep {
+			continue
+		}
+		// Trashing the file a kept symlink points to would lose it
+		if info, err := os.Stat(path); err == nil && kept != nil && os.SameFile(info, kept) {
+			continue
+		}
+		if err := trash.Trash(path); err != nil {
+			slog.Warn("Failed to move duplicate to trash", "path", path, "error", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		errDlg := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE,
+			"%d copies could not be moved to the trash", failed)
+		errDlg.Run()
+		errDlg.Destroy()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package gui
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"waller/internal/metadata"
+)
+
+// itemRecord returns the favorites and tags record of the image at path.
+// ok is false while the image's content hash is not yet known.
+func itemRecord(path string) (metadata.Record, bool) {
+	hash, ok := contentHash(path)
+	if !ok {
+		return metadata.Record{}, false
+	}
+	return globalMeta.Get(hash), true
+}
+
+// contentHash returns the content hash of the image at path from the metadata index.
+func contentHash(path string) (string, bool) {
+	if globalIndex == nil || globalMeta == nil {
+		return "", false
+	}
+	e, ok := globalIndex.Get(path)
+	return e.Hash, ok
+}
+
+// toggleFavorite flips the favorite mark of an item.
+func toggleFavorite(item *wallpaperItem) {
+	hash, ok := contentHash(item.path)
+	if !ok {
+		return
+	}
+	r := globalMeta.Get(hash)
+	if err := globalMeta.SetFavorite(hash, !r.Favorite); err != nil {
+		slog.Warn("Failed to save favorite", "error", err)
+	}
+	updateBadge(item.path, item)
+	item.child.SetVisible(itemMatches(item))
+}
+
+// editTags lets the user edit the tags of an item as a comma-separated list.
+func editTags(item *wallpaperItem) {
+	hash, ok := contentHash(item.path)
+	if !ok {
+		return
+	}
+
+	dlg, _ := gtk.DialogNewWithButtons("Tags for "+filepath.Base(item.path), globalWindow, gtk.DIALOG_MODAL,
+		[]interface{}{"Cancel", gtk.RESPONSE_CANCEL},
+		[]interface{}{"Save", gtk.RESPONSE_ACCEPT})
+	dlg.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+
+	entry, _ := gtk.EntryNew()
+	entry.SetPlaceholderText("nature, dark, mountains")
+	entry.SetText(strings.Join(globalMeta.Get(hash).Tags, ", "))
+	entry.SetActivatesDefault(true)
+
+	content, _ := dlg.GetContentArea()
+	content.PackStart(entry, true, true, 5)
+	content.ShowAll()
+
+	if dlg.Run() == gtk.RESPONSE_ACCEPT {
+		text, _ := entry.GetText()
+		if err := globalMeta.SetTags(hash, metadata.ParseTags(text)); err != nil {
+			slog.Warn("Failed to save tags", "error", err)
+		}
+		updateBadge(item.path, item)
+		item.child.SetVisible(itemMatches(item))
+	}
+	dlg.Destroy()
+}
@@ -0,0 +1,185 @@
+package gui
+
+import (
+	"slices"
+
+	"github.com/gotk3/gotk3/gtk"
+
+	"waller/internal/cache"
+	"waller/internal/config"
+	"waller/internal/metadata"
+	"waller/internal/order"
+	"waller/internal/palette"
+	"waller/internal/vfs"
+)
+
+// Grid filter and ordering state (GTK main thread only)
+var (
+	// colorFilter hides wallpapers not dominated by this hue ("" = show all).
+	colorFilter string
+	// sortOrder is the order of the grid, stored in the config.
+	sortOrder order.Order
+	// favoritesOnly hides wallpapers not marked as favorite.
+	favoritesOnly bool
+	// tagFilter hides wallpapers without at least one of these tags (empty = show all).
+	tagFilter []string
+)
+
+// newFilterBar builds the row of grid filter and ordering controls.
+func newFilterBar() *gtk.Box {
+	bar, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	bar.SetMarginStart(5)
+
+	// Color Filter
+	colorCombo, _ := gtk.ComboBoxTextNew()
+	colorCombo.AppendText("Any Color") // Index 0 → no filter
+	for _, name := range palette.Hues {
+		colorCombo.AppendText(name)
+	}
+	colorCombo.SetActive(0)
+	colorCombo.Connect("changed", func() {
+		colorFilter = ""
+		if colorCombo.GetActive() > 0 {
+			colorFilter = colorCombo.GetActiveText()
+		}
+		applyFilter()
+	})
+	bar.PackStart(colorCombo, false, false, 0)
+
+	// Favorites and Tags
+	favBtn, _ := gtk.ToggleButtonNewWithLabel("★ Favorites")
+	favBtn.Connect("toggled", func() {
+		favoritesOnly = favBtn.GetActive()
+		applyFilter()
+	})
+	bar.PackStart(favBtn, false, false, 0)
+
+	tagEntry, _ := gtk.SearchEntryNew()
+	tagEntry.SetPlaceholderText("Tags")
+	tagEntry.Connect("search-changed", func() {
+		text, _ := tagEntry.GetText()
+		tagFilter = metadata.ParseTags(text)
+		applyFilter()
+	})
+	bar.PackStart(tagEntry, false, false, 0)
+
+	return bar
+}
+
+// filtersActive reports whether any filter hides wallpapers.
+func filtersActive() bool {
+	return colorFilter != "" || favoritesOnly || len(tagFilter) > 0
+}
+
+// itemMatches reports whether an item passes the active filters.
+func itemMatches(item *wallpaperItem) bool {
+	if colorFilter != "" && !palette.Matches(item.palette, colorFilter) {
+		return false
+	}
+	if favoritesOnly || len(tagFilter) > 0 {
+		r, _ := itemRecord(item.path)
+		if favoritesOnly && !r.Favorite {
+			return false
+		}
+		if len(tagFilter) > 0 && !r.HasAnyTag(tagFilter) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyFilter shows or hides every grid item according to the active filters.
+func applyFilter() {
+	for _, item := range globalItems {
+		item.child.SetVisible(itemMatches(item))
+	}
+}
+
+// shownFiles returns the files whose grid items pass the active filters.
+func shownFiles(files []string) []string {
+	if !filtersActive() {
+		return files
+	}
+	shown := make([]string, 0, len(files))
+	for _, path := range files {
+		if item, ok := globalItems[path]; ok && itemMatches(item) {
+			shown = append(shown, path)
+		}
+	}
+	return shown
+}
+
+// sortLabels are the sort menu entries, index-aligned with order.Keys.
+var sortLabels = []string{"Name", "Date", "Size", "Resolution", "Hue", "Random"}
+
+// newSortControls builds the sort dropdown and direction toggle for the header bar.
+// Changes reorder the grid and are saved to cfg.
+func newSortControls(cfg *config.Config) (*gtk.ComboBoxText, *gtk.ToggleButton) {
+	sortOrder = order.Order{Key: order.Key(cfg.SortBy), Descending: cfg.SortDescending}
+	if !slices.Contains(order.Keys, sortOrder.Key) {
+		sortOrder.Key = order.Name // Natural name order by default
+	}
+
+	combo, _ := gtk.ComboBoxTextNew()
+	for _, label := range sortLabels {
+		combo.AppendText(label)
+	}
+	combo.SetActive(slices.Index(order.Keys, sortOrder.Key))
+	combo.SetTooltipText("Sort order")
+
+	descBtn, _ := gtk.ToggleButtonNewWithLabel("↓")
+	descBtn.SetActive(sortOrder.Descending)
+	descBtn.SetTooltipText("Descending")
+
+	changed := func() {
+		sortOrder = order.Order{Key: order.Keys[max(combo.GetActive(), 0)], Descending: descBtn.GetActive()}
+		cfg.SortBy = string(sortOrder.Key)
+		cfg.SortDescending = sortOrder.Descending
+		cfg.Save()
+		reorderGrid()
+	}
+	combo.Connect("changed", changed)
+	descBtn.Connect("toggled", changed)
+
+	return combo, descBtn
+}
+
+// sortInfo returns what the grid order needs to know about the wallpaper at path,
+// preferring the metadata index over touching the file.
+func sortInfo(path string) order.Info {
+	var info order.Info
+	if e, ok := indexedEntry(path); ok {
+		info.ModTime, info.Size, info.Width, info.Height = e.ModTime, e.Size, e.Width, e.Height
+	} else if fi, err := vfs.Stat(path); err == nil {
+		info.ModTime, info.Size = fi.ModTime(), fi.Size()
+	}
+	m, _ := cache.GetMeta(path)
+	info.Hue = palette.SortKey(m.Palette)
+	return info
+}
+
+// sortFiles returns a copy of files in grid order o. Safe off the main thread.
+func sortFiles(files []string, o order.Order) []string {
+	sorted := slices.Clone(files)
+	order.Sort(sorted, o, sortInfo)
+	return sorted
+}
+
+// reorderGrid re-inserts the grid items in the selected sort order.
+func reorderGrid() {
+	globalFilesMu.Lock()
+	paths := sortFiles(globalFiles, sortOrder)
+	globalFilesMu.Unlock()
+
+	for _, path := range paths {
+		item, ok := globalItems[path]
+		if !ok {
+			continue
+		}
+		// Hold a reference so the child survives leaving the container
+		item.child.Ref()
+		globalFlowBox.Remove(item.child)
+		globalFlowBox.Insert(item.child, -1)
+		item.child.Unref()
+	}
+}
@@ -8,8 +8,9 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/glib"
@@ -18,7 +19,16 @@ import (
 	"waller/internal/backend"
 	"waller/internal/cache"
 	"waller/internal/config"
+	"waller/internal/fit"
+	"waller/internal/index"
 	"waller/internal/manager"
+	"waller/internal/metadata"
+	"waller/internal/monitor"
+	"waller/internal/order"
+	"waller/internal/palette"
+	"waller/internal/pixbuf"
+	"waller/internal/provider"
+	"waller/internal/watcher"
 )
 
 // Global state for async wallpaper loading and monitor selection
@@ -27,8 +37,38 @@ var (
 	globalFiles          []string
 	globalFilesMu        sync.Mutex
 	selectedMonitorIndex int
+	// selectedLibrary is the name of the library shown in the grid ("" = all enabled libraries).
+	selectedLibrary   string
+	populatingLibrary bool
+	// loadGeneration is bumped on every reload so batches from a superseded load are dropped.
+	loadGeneration int
+	// globalItems maps each shown wallpaper to its grid cell (GTK main thread only).
+	globalItems   map[string]*wallpaperItem
+	globalWatcher *watcher.Watcher
+	// globalIndex holds persistent image metadata; nil if it could not be opened.
+	globalIndex *index.Index
+	// globalMeta holds favorites and tags; nil if it could not be opened.
+	globalMeta   *metadata.Store
+	globalWindow *gtk.Window
+	// globalMonitors is the physical size of each monitor, in GDK index order.
+	globalMonitors []fit.Monitor
+	// matchMonitor restricts Random to wallpapers that suit the selected monitor.
+	matchMonitor bool
 )
 
+// wallpaperItem is one cell of the wallpaper grid.
+type wallpaperItem struct {
+	path    string
+	child   *gtk.FlowBoxChild
+	image   *gtk.Image
+	badge   *gtk.Label
+	star    *gtk.Button
+	palette []palette.Color
+}
+
+// watchDebounce is how long the libraries must be quiet before the grid is updated.
+const watchDebounce = time.Second
+
 func Run() error {
 	gtk.Init(nil)
 
@@ -50,6 +90,19 @@ func Run() error {
 		slog.Warn("Failed to load config", "error", err)
 		cfg = new(config.Config)
 	}
+	cache.ShareThumbnails(cfg.ShareThumbnails)
+	cache.UseLayout(cache.ParseLayout(cfg.ThumbnailStore))
+	cache.SetScaledDecoder(pixbuf.DecodeScaled)
+
+	globalIndex, err = index.Open()
+	if err != nil {
+		slog.Warn("Failed to open metadata index", "error", err)
+	}
+	globalMeta, err = metadata.Open()
+	if err != nil {
+		slog.Warn("Failed to open favorites and tags", "error", err)
+	}
+	globalWindow = win
 
 	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 10)
 	win.Add(vbox)
@@ -59,19 +112,39 @@ func Run() error {
 	header.SetTitle("Waller")
 	win.SetTitlebar(header)
 
-	dirBtn, _ := gtk.ButtonNewWithLabel("Open Directory")
+	libraryCombo, _ := gtk.ComboBoxTextNew()
+
+	dirBtn, _ := gtk.ButtonNewWithLabel("Add Library")
 	dirBtn.Connect("clicked", func() {
 		dlg, _ := gtk.FileChooserNativeDialogNew("Select Wallpaper Dir", win, gtk.FILE_CHOOSER_ACTION_SELECT_FOLDER, "Select", "Cancel")
 		resp := dlg.Run()
 		if resp == int(gtk.RESPONSE_ACCEPT) {
 			folder := dlg.GetFilename()
-			cfg.WallpaperDir = folder
+			lib := cfg.AddLibrary(folder)
 			cfg.Save()
-			loadWallpapers(folder)
+			selectedLibrary = lib.Name
+			refreshLibraries(libraryCombo, cfg)
+			loadWallpapers(libraryDirs(cfg), backend.OptionsFromConfig(cfg))
 		}
 		dlg.Destroy()
 	})
 	header.PackStart(dirBtn)
+
+	// Library Selection — "All Libraries" merges every enabled library
+	libraryCombo.Connect("changed", func() {
+		if populatingLibrary {
+			return
+		}
+		if libraryCombo.GetActive() <= 0 {
+			selectedLibrary = ""
+		} else {
+			selectedLibrary = libraryCombo.GetActiveText()
+		}
+		loadWallpapers(libraryDirs(cfg), backend.OptionsFromConfig(cfg))
+	})
+	refreshLibraries(libraryCombo, cfg)
+	header.PackStart(libraryCombo)
+
 	// Monitor Selection
 	monitorCombo, _ := gtk.ComboBoxTextNew()
 	refreshMonitors(monitorCombo)
@@ -86,6 +159,7 @@ func Run() error {
 		} else {
 			selectedMonitorIndex = active - 1 // 0-based index for GDK
 		}
+		refreshBadges()
 	})
 
 	// Refresh Button — re-detects monitors and reloads wallpapers
@@ -94,18 +168,30 @@ func Run() error {
 		refreshMonitors(monitorCombo)
 		selectedMonitorIndex = -1
 
-		if cfg.WallpaperDir != "" {
-			loadWallpapers(cfg.WallpaperDir)
-		}
+		loadWallpapers(libraryDirs(cfg), backend.OptionsFromConfig(cfg))
 	})
 	header.PackStart(refreshBtn)
 
+	// Sort Order — stored in the config
+	sortCombo, descBtn := newSortControls(cfg)
+	header.PackStart(sortCombo)
+	header.PackStart(descBtn)
+
+	// Zoom — steps through the configured thumbnail sizes
+	zoomOut, zoomIn := newZoomControls(cfg)
+	header.PackStart(zoomOut)
+	header.PackStart(zoomIn)
+
 	randBtn, _ := gtk.ButtonNewWithLabel("Random")
 	randBtn.Connect("clicked", func() {
 		globalFilesMu.Lock()
 		files := globalFiles
 		globalFilesMu.Unlock()
 
+		files = shownFiles(files)
+		if matchMonitor {
+			files = fit.Filter(files, indexedSize, selectedMonitors())
+		}
 		if len(files) > 0 {
 			ri := rand.IntN(len(files))
 			applyWallpaper(files[ri])
@@ -113,6 +199,34 @@ func Run() error {
 	})
 	header.PackEnd(randBtn)
 
+	// Match Toggle — Random only picks wallpapers that suit the selected monitor
+	matchBtn, _ := gtk.CheckButtonNewWithLabel("Match Monitor")
+	matchBtn.Connect("toggled", func() {
+		matchMonitor = matchBtn.GetActive()
+	})
+	header.PackEnd(matchBtn)
+
+	dupBtn, _ := gtk.ButtonNewWithLabel("Duplicates")
+	dupBtn.Connect("clicked", func() {
+		globalFilesMu.Lock()
+		files := globalFiles
+		globalFilesMu.Unlock()
+
+		showDuplicates(win, files)
+	})
+	header.PackEnd(dupBtn)
+
+	// Online — browse the galleries listed under "providers" in the config
+	if providers := provider.FromConfig(provider.Decode(cfg.Providers)); len(providers) > 0 {
+		onlineBtn, _ := gtk.ButtonNewWithLabel("Online")
+		onlineBtn.Connect("clicked", func() {
+			showOnline(win, cfg, providers)
+		})
+		header.PackEnd(onlineBtn)
+	}
+
+	vbox.PackStart(newFilterBar(), false, false, 0)
+
 	scroll, _ := gtk.ScrolledWindowNew(nil, nil)
 	scroll.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
 	vbox.PackStart(scroll, true, true, 0)
@@ -126,24 +240,63 @@ func Run() error {
 	globalFlowBox = flowBox
 
 	win.ShowAll()
+	trackScale(win)
 
-	if cfg.WallpaperDir != "" {
-		loadWallpapers(cfg.WallpaperDir)
+	// Evict thumbnails over the configured limits, least recently used first
+	limits := cache.Limits{
+		MaxSize: int64(cfg.ThumbnailCacheMaxMB) << 20,
+		MaxAge:  time.Duration(cfg.ThumbnailCacheMaxDays) * 24 * time.Hour,
+	}
+	if limits != (cache.Limits{}) {
+		go func() {
+			if r, err := cache.Prune(limits); err != nil {
+				slog.Warn("Failed to prune thumbnail cache", "error", err)
+			} else if r.Count > 0 {
+				slog.Info("Pruned thumbnail cache", "thumbnails", r.Count, "bytes", r.Bytes)
+			}
+		}()
 	}
 
+	loadWallpapers(libraryDirs(cfg), backend.OptionsFromConfig(cfg))
+
 	gtk.Main()
 	return nil
 }
 
-func loadWallpapers(dir string) {
+func loadWallpapers(dirs []string, opts backend.Options) {
 	// Clear existing
 	children := globalFlowBox.GetChildren()
 	children.Foreach(func(item interface{}) {
 		globalFlowBox.Remove(item.(*gtk.Widget))
 	})
+	globalItems = make(map[string]*wallpaperItem)
+
+	loadGeneration++
+	gen := loadGeneration
+
+	// Watch only the directories that are shown
+	if globalWatcher != nil {
+		globalWatcher.Close()
+		globalWatcher = nil
+	}
+
+	if len(dirs) == 0 {
+		globalFilesMu.Lock()
+		globalFiles = nil
+		globalFilesMu.Unlock()
+		return
+	}
 
+	if w, err := watcher.New(dirs, opts.FollowSymlinks, watchDebounce); err != nil {
+		slog.Warn("Live directory watching unavailable", "error", err)
+	} else {
+		globalWatcher = w
+		go watchWallpapers(w, gen, dirs, opts)
+	}
+
+	o, width := sortOrder, thumbWidth()
 	go func() {
-		files, err := backend.GetWallpapers(dir)
+		files, err := backend.GetWallpapers(dirs, opts)
 		if err != nil {
 			slog.Error("Failed to load wallpapers", "error", err)
 			return
@@ -153,68 +306,171 @@ func loadWallpapers(dir string) {
 		globalFiles = files
 		globalFilesMu.Unlock()
 
-		// Pre-generate missing thumbnails with a bounded worker pool
-		numWorkers := runtime.NumCPU()
-		jobs := make(chan string, numWorkers)
-
-		var wg sync.WaitGroup
-		for range numWorkers {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for path := range jobs {
-					cache.GetThumbnail(path, false)
-				}
-			}()
+		go updateIndex(files, true)
+		addWallpapers(gen, files, o, width)
+	}()
+}
+
+// watchWallpapers rescans dirs after each batch of file changes and
+// applies the difference to the grid of load generation gen.
+func watchWallpapers(w *watcher.Watcher, gen int, dirs []string, opts backend.Options) {
+	for changed := range w.Changes() {
+		files, err := backend.GetWallpapers(dirs, opts)
+		if err != nil {
+			slog.Warn("Rescan failed", "error", err)
+			continue
 		}
 
-		// Enqueue files that need thumbnails
-		for _, path := range files {
-			if _, err := cache.GetThumbnail(path, true); err != nil {
-				jobs <- path
+		glib.IdleAdd(func() bool {
+			if gen == loadGeneration {
+				syncWallpapers(gen, files, changed)
 			}
-		}
-		close(jobs)
-		wg.Wait()
+			return false // Run once
+		})
+	}
+}
 
-		// Batch load to UI (thumbnails are all cached now)
-		batchSize := 20
-		total := len(files)
+// syncWallpapers updates the grid incrementally to show exactly files.
+// Items whose path is in changed are rebuilt so edited images get a fresh thumbnail.
+// Must run on the GTK main thread.
+func syncWallpapers(gen int, files []string, changed []string) {
+	present := make(map[string]bool, len(files))
+	for _, path := range files {
+		present[path] = true
+	}
 
-		for i := 0; i < total; i += batchSize {
-			end := i + batchSize
-			if end > total {
-				end = total
+	var removed []string
+	for _, path := range changed {
+		if item, ok := globalItems[path]; ok {
+			globalFlowBox.Remove(item.child)
+			delete(globalItems, path)
+			if !present[path] {
+				removed = append(removed, path)
 			}
+		}
+	}
+	for path, item := range globalItems {
+		if !present[path] {
+			globalFlowBox.Remove(item.child)
+			delete(globalItems, path)
+			removed = append(removed, path)
+		}
+	}
+
+	var added []string
+	for _, path := range files {
+		if _, ok := globalItems[path]; !ok {
+			added = append(added, path)
+		}
+	}
+
+	globalFilesMu.Lock()
+	globalFiles = files
+	globalFilesMu.Unlock()
+
+	if globalIndex != nil {
+		globalIndex.Remove(removed...)
+	}
+	if len(added) > 0 {
+		o, width := sortOrder, thumbWidth()
+		go func() {
+			updateIndex(added, false)
+			addWallpapers(gen, added, o, width)
+		}()
+	}
+}
+
+// updateIndex refreshes the metadata index for paths and saves it.
+// With prune set, entries for files that no longer exist are dropped as well.
+func updateIndex(paths []string, prune bool) {
+	if globalIndex == nil {
+		return
+	}
+	globalIndex.Update(paths)
+	if prune {
+		globalIndex.Prune()
+	}
+	if err := globalIndex.Save(); err != nil {
+		slog.Warn("Failed to save metadata index", "error", err)
+	}
+
+	// Badges, tag filters and sorting by resolution depend on index data,
+	// which may only be known now
+	glib.IdleAdd(func() bool {
+		refreshBadges()
+		applyFilter()
+		if sortOrder.Key == order.Resolution {
+			reorderGrid()
+		}
+		return false // Run once
+	})
+}
+
+// addWallpapers generates missing thumbnails width pixels wide for files and then
+// adds them to the grid of load generation gen in batches, in order o. Runs off the main thread.
+func addWallpapers(gen int, files []string, o order.Order, width int) {
+	// Pre-generate missing thumbnails and colors with a bounded worker pool
+	var missing []string
+	for _, path := range files {
+		if _, err := cache.GetThumbnail(path, width, true); err != nil {
+			missing = append(missing, path)
+		} else if m, ok := cache.GetMeta(path); !ok || !m.Complete() {
+			missing = append(missing, path)
+		}
+	}
+	forEach(missing, func(path string) {
+		// The thumbnail yields the colors too, so Analyze rarely needs another
+		if _, err := cache.GetThumbnail(path, width, false); err != nil {
+			slog.Debug("Could not generate thumbnail", "path", path, "error", err)
+		}
+		cache.Analyze(path)
+	})
+
+	if err := cache.SaveMeta(); err != nil {
+		slog.Warn("Failed to save thumbnail metadata", "error", err)
+	}
+
+	// Batch load to UI (thumbnails are all cached now), sorted up front
+	// so the grid fills in order; colors are known by now
+	files = sortFiles(files, o)
+	batchSize := 20
+	total := len(files)
 
-			batch := files[i:end]
+	for i := 0; i < total; i += batchSize {
+		end := i + batchSize
+		if end > total {
+			end = total
+		}
+
+		batch := files[i:end]
+		lastBatch := end == total
 
-			glib.IdleAdd(func() bool {
-				for _, path := range batch {
+		glib.IdleAdd(func() bool {
+			if gen != loadGeneration {
+				return false // A newer load replaced this one
+			}
+			for _, path := range batch {
+				if _, ok := globalItems[path]; !ok {
 					addWallpaperItem(path)
 				}
-				return false // Run once
-			})
-		}
-	}()
+			}
+			// Files added to a library land after the rest; move them into
+			// place, but keep a random order stable
+			if lastBatch && len(globalItems) > total && o.Key != order.Random {
+				reorderGrid()
+			}
+			return false // Run once
+		})
+	}
 }
 
 func addWallpaperItem(path string) {
 	// Container
 	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
 
-	thumbPath, err := cache.GetThumbnail(path, true)
-	if err != nil {
-		thumbPath = path // Fallback to full image
-	}
-
-	pixbuf, _ := gdk.PixbufNewFromFileAtScale(thumbPath, 150, 100, true)
-	img, _ := gtk.ImageNewFromPixbuf(pixbuf)
-	// Free the pixbuf after creating the image to release memory
-	if pixbuf != nil {
-		pixbuf.Unref()
-	}
+	img := thumbnailImage(path)
 	img.Show()
+	item := &wallpaperItem{path: path, image: img}
 
 	imgBtn, _ := gtk.ButtonNew()
 	imgBtn.SetRelief(gtk.RELIEF_NONE)
@@ -235,13 +491,146 @@ func addWallpaperItem(path string) {
 	lbl.Show()
 	vbox.PackStart(lbl, false, false, 0)
 
+	// Favorite star, badges for warnings such as upscaling, and tag editor
+	row, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 2)
+
+	item.star, _ = gtk.ButtonNewWithLabel("☆")
+	item.star.SetRelief(gtk.RELIEF_NONE)
+	item.star.Connect("clicked", func() {
+		toggleFavorite(item)
+	})
+	row.PackStart(item.star, false, false, 0)
+
+	item.badge, _ = gtk.LabelNew("")
+	row.PackStart(item.badge, true, true, 0)
+
+	tagBtn, _ := gtk.ButtonNewWithLabel("#")
+	tagBtn.SetRelief(gtk.RELIEF_NONE)
+	tagBtn.SetTooltipText("Edit tags")
+	tagBtn.Connect("clicked", func() {
+		editTags(item)
+	})
+	row.PackEnd(tagBtn, false, false, 0)
+
+	row.ShowAll()
+	vbox.PackStart(row, false, false, 0)
+
 	vbox.Show()
-	globalFlowBox.Add(vbox)
+
+	// Wrap in our own FlowBoxChild so the cell can be removed by path later
+	child, _ := gtk.FlowBoxChildNew()
+	child.Add(vbox)
+	child.Show()
+	globalFlowBox.Add(child)
+
+	item.child = child
+	if m, ok := cache.GetMeta(path); ok {
+		item.palette = m.Palette
+	}
+	globalItems[path] = item
+	updateBadge(path, item)
+	child.SetVisible(itemMatches(item))
+}
+
+// updateBadge shows the markers that apply to the wallpaper at path,
+// e.g. that it would be upscaled on the selected monitor, and its favorite state and tags.
+func updateBadge(path string, item *wallpaperItem) {
+	var markers, notes []string
+
+	r, ok := itemRecord(path)
+	item.star.SetSensitive(ok) // Annotations need the content hash from the index
+	if r.Favorite {
+		item.star.SetLabel("★")
+	} else {
+		item.star.SetLabel("☆")
+	}
+	if len(r.Tags) > 0 {
+		notes = append(notes, "Tags: "+strings.Join(r.Tags, ", "))
+	}
+
+	if e, ok := indexedEntry(path); ok && e.Animated() {
+		markers = append(markers, "▶ animated")
+		notes = append(notes, fmt.Sprintf("Animated, %d frames", e.Frames))
+	}
+
+	if w, h, ok := indexedSize(path); ok {
+		for _, mon := range selectedMonitors() {
+			if fit.Upscaled(w, h, mon) {
+				markers = append(markers, "▲ upscaled")
+				notes = append(notes, fmt.Sprintf("%d×%d image is smaller than the %d×%d monitor", w, h, mon.Width, mon.Height))
+				break
+			}
+		}
+	}
+
+	item.badge.SetText(strings.Join(markers, " "))
+	item.child.SetTooltipText(strings.Join(notes, "\n"))
+}
+
+// refreshBadges updates the markers of every item in the grid.
+func refreshBadges() {
+	for path, item := range globalItems {
+		updateBadge(path, item)
+	}
+}
+
+// indexedSize returns the pixel size of the image at path from the metadata index.
+func indexedSize(path string) (int, int, bool) {
+	e, ok := indexedEntry(path)
+	return e.Width, e.Height, ok
+}
+
+// indexedEntry returns the metadata index entry of the image at path.
+func indexedEntry(path string) (index.Entry, bool) {
+	if globalIndex == nil {
+		return index.Entry{}, false
+	}
+	return globalIndex.Get(path)
+}
+
+// selectedMonitors returns the monitors the selected monitor index applies to.
+func selectedMonitors() []fit.Monitor {
+	return monitor.Targets(globalMonitors, selectedMonitorIndex)
+}
+
+// refreshLibraries clears and repopulates the library combo box from the
+// enabled libraries, keeping the current selection when it still exists.
+func refreshLibraries(combo *gtk.ComboBoxText, cfg *config.Config) {
+	populatingLibrary = true
+	defer func() { populatingLibrary = false }()
+
+	combo.RemoveAll()
+	combo.AppendText("All Libraries") // Index 0 → selectedLibrary ""
+
+	active := 0
+	for i, lib := range cfg.EnabledLibraries() {
+		combo.AppendText(lib.Name)
+		if lib.Name == selectedLibrary {
+			active = i + 1
+		}
+	}
+	if active == 0 {
+		selectedLibrary = ""
+	}
+	combo.SetActive(active)
+}
+
+// libraryDirs returns the directories of the selected library,
+// or of every enabled library when "All Libraries" is selected.
+func libraryDirs(cfg *config.Config) []string {
+	if selectedLibrary != "" {
+		if lib := cfg.FindLibrary(selectedLibrary); lib != nil {
+			return []string{lib.Path}
+		}
+	}
+	return cfg.EnabledDirs()
 }
 
 // refreshMonitors clears and repopulates the monitor combo box
 // from the current GDK display state.
 func refreshMonitors(combo *gtk.ComboBoxText) {
+	globalMonitors = monitor.Geometries()
+
 	combo.RemoveAll()
 	combo.AppendText("All") // Index 0 → monitorIndex -1
 
@@ -259,6 +648,28 @@ func refreshMonitors(combo *gtk.ComboBoxText) {
 	combo.SetActive(0)
 }
 
+// applyMu keeps wallpapers applied in quick succession from racing each other.
+var applyMu sync.Mutex
+
+// applyWallpaper sets path on the selected monitor. The image may have to be
+// converted or extracted from an archive first, so this runs off the main
+// thread and a failure is reported back on it.
 func applyWallpaper(path string) {
-	manager.ApplyWallpaper(path, selectedMonitorIndex)
+	monitorIndex := selectedMonitorIndex
+	go func() {
+		applyMu.Lock()
+		err := manager.ApplyWallpaper(path, monitorIndex)
+		applyMu.Unlock()
+		if err == nil {
+			return
+		}
+		glib.IdleAdd(func() bool {
+			slog.Warn("Failed to apply wallpaper", "path", path, "error", err)
+			dlg := gtk.MessageDialogNew(globalWindow, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE,
+				"Could not apply %s: %v", filepath.Base(path), err)
+			dlg.Run()
+			dlg.Destroy()
+			return false // Run once
+		})
+	}()
 }
@@ -0,0 +1,215 @@
+package gui
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+
+	"waller/internal/config"
+	"waller/internal/provider"
+	"waller/internal/remote"
+)
+
+// showOnline opens a window browsing the configured online galleries.
+// Results can be added to a library or added and applied right away.
+func showOnline(parent *gtk.Window, cfg *config.Config, providers []provider.Provider) {
+	win, _ := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
+	win.SetTitle("Online")
+	win.SetTransientFor(parent)
+	win.SetDefaultSize(800, 600)
+
+	vbox, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 10)
+	win.Add(vbox)
+
+	bar, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	bar.SetMarginTop(5)
+	bar.SetMarginStart(5)
+	bar.SetMarginEnd(5)
+	vbox.PackStart(bar, false, false, 0)
+
+	providerCombo, _ := gtk.ComboBoxTextNew()
+	for _, p := range providers {
+		providerCombo.AppendText(p.Name())
+	}
+	providerCombo.SetActive(0)
+	bar.PackStart(providerCombo, false, false, 0)
+
+	searchEntry, _ := gtk.SearchEntryNew()
+	searchEntry.SetPlaceholderText("Search")
+	bar.PackStart(searchEntry, true, true, 0)
+
+	moreBtn, _ := gtk.ButtonNewWithLabel("More")
+	bar.PackEnd(moreBtn, false, false, 0)
+
+	status, _ := gtk.LabelNew("")
+	status.SetXAlign(0)
+	status.SetMarginStart(5)
+	vbox.PackStart(status, false, false, 0)
+
+	scroll, _ := gtk.ScrolledWindowNew(nil, nil)
+	scroll.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
+	vbox.PackStart(scroll, true, true, 0)
+
+	flowBox, _ := gtk.FlowBoxNew()
+	flowBox.SetVAlign(gtk.ALIGN_START)
+	flowBox.SetMaxChildrenPerLine(30)
+	flowBox.SetSelectionMode(gtk.SELECTION_NONE)
+	scroll.Add(flowBox)
+
+	// Results of a superseded query are dropped, like batches of an old library load
+	var gen, page int
+	var query string
+
+	load := func(reset bool) {
+		p := providers[max(providerCombo.GetActive(), 0)]
+		if reset {
+			gen++
+			page = 0
+			query, _ = searchEntry.GetText()
+			flowBox.GetChildren().Foreach(func(item interface{}) {
+				flowBox.Remove(item.(*gtk.Widget))
+			})
+		}
+		page++
+		myGen, myPage, myQuery := gen, page, query
+		status.SetText("Loading…")
+		moreBtn.SetSensitive(false)
+
+		go func() {
+			var images []provider.Image
+			var err error
+			if myQuery == "" {
+				images, err = p.List(myPage)
+			} else {
+				images, err = p.Search(myQuery, myPage)
+			}
+
+			glib.IdleAdd(func() bool {
+				if myGen != gen {
+					return false
+				}
+				moreBtn.SetSensitive(err == nil && len(images) > 0)
+				switch {
+				case err != nil:
+					slog.Warn("Online gallery request failed", "provider", p.Name(), "error", err)
+					status.SetText("Request failed: " + err.Error())
+				case len(images) == 0 && myPage == 1:
+					status.SetText("No results")
+				default:
+					status.SetText(fmt.Sprintf("%s, page %d", p.Name(), myPage))
+				}
+				for _, img := range images {
+					flowBox.Add(onlineItem(win, cfg, p, img, status))
+				}
+				return false // Run once
+			})
+		}()
+	}
+
+	providerCombo.Connect("changed", func() { load(true) })
+	searchEntry.Connect("activate", func() { load(true) })
+	moreBtn.Connect("clicked", func() { load(false) })
+
+	win.ShowAll()
+	load(true)
+}
+
+// onlineItem builds the cell for one gallery result. Its preview is downloaded
+// in the background.
+func onlineItem(win *gtk.Window, cfg *config.Config, p provider.Provider, img provider.Image, status *gtk.Label) *gtk.FlowBoxChild {
+	cell, _ := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+
+	preview, _ := gtk.ImageNewFromIconName("image-loading", gtk.ICON_SIZE_DIALOG)
+	preview.SetSizeRequest(150, 100)
+	cell.PackStart(preview, false, false, 0)
+
+	info := img.Title
+	if img.Width > 0 && img.Height > 0 {
+		info = fmt.Sprintf("%s\n%d×%d", info, img.Width, img.Height)
+	}
+	lbl, _ := gtk.LabelNew(info)
+	lbl.SetMaxWidthChars(20)
+	cell.PackStart(lbl, false, false, 0)
+	cell.SetTooltipText(img.URL)
+
+	buttons, _ := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 5)
+	addBtn, _ := gtk.ButtonNewWithLabel("Add")
+	applyBtn, _ := gtk.ButtonNewWithLabel("Apply")
+	buttons.PackStart(addBtn, true, true, 0)
+	buttons.PackStart(applyBtn, true, true, 0)
+	cell.PackStart(buttons, false, false, 0)
+
+	download := func(apply bool) {
+		lib := downloadLibrary(cfg)
+		if lib == nil {
+			dlg := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_CLOSE,
+				"Add a library first; downloads are saved into it")
+			dlg.Run()
+			dlg.Destroy()
+			return
+		}
+		dir, name := lib.Path, lib.Name
+		status.SetText("Downloading " + img.Title + "…")
+
+		go func() {
+			path, err := provider.AddToLibrary(p, img, dir)
+			glib.IdleAdd(func() bool {
+				if err != nil {
+					slog.Warn("Download failed", "url", img.URL, "error", err)
+					status.SetText("Download failed: " + err.Error())
+					return false
+				}
+				status.SetText(fmt.Sprintf("Added %s to %s", img.Title, name))
+				if apply {
+					applyWallpaper(path)
+				}
+				return false // Run once
+			})
+		}()
+	}
+	addBtn.Connect("clicked", func() { download(false) })
+	applyBtn.Connect("clicked", func() { download(true) })
+
+	child, _ := gtk.FlowBoxChildNew()
+	child.Add(cell)
+	child.ShowAll()
+
+	thumbURL := img.ThumbURL
+	if thumbURL == "" {
+		thumbURL = img.URL
+	}
+	go func() {
+		path, err := remote.Fetch(thumbURL)
+		if err != nil {
+			slog.Warn("Failed to download preview", "url", thumbURL, "error", err)
+			return
+		}
+		glib.IdleAdd(func() bool {
+			pixbuf, err := gdk.PixbufNewFromFileAtScale(path, 150, 100, true)
+			if err != nil {
+				return false
+			}
+			preview.SetFromPixbuf(pixbuf)
+			return false // Run once
+		})
+	}()
+
+	return child
+}
+
+// downloadLibrary returns the library downloads are saved into: the one shown
+// in the grid, or the first enabled one when all are shown.
+func downloadLibrary(cfg *config.Config) *config.Library {
+	if selectedLibrary != "" {
+		if lib := cfg.FindLibrary(selectedLibrary); lib != nil {
+			return lib
+		}
+	}
+	if libs := cfg.EnabledLibraries(); len(libs) > 0 {
+		return &libs[0]
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package gui
+
+import (
+	"github.com/gotk3/gotk3/gdk"
+)
+
+// pixbufFromData decodes encoded image data with gdk-pixbuf, scaled down to
+// fit width by height if it is larger.
+func pixbufFromData(data []byte, width, height int) (*gdk.Pixbuf, error) {
+	pixbuf, err := gdk.PixbufNewFromDataOnly(data)
+	if err != nil {
+		return nil, err
+	}
+	w, h := pixbuf.GetWidth(), pixbuf.GetHeight()
+	if w <= width && h <= height {
+		return pixbuf, nil
+	}
+	scale := min(float64(width)/float64(w), float64(height)/float64(h))
+	return pixbuf.ScaleSimple(max(int(float64(w)*scale), 1), max(int(float64(h)*scale), 1), gdk.INTERP_BILINEAR)
+}
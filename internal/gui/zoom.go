@@ -0,0 +1,160 @@
+package gui
+
+import (
+	"log/slog"
+	"runtime"
+	"slices"
+	"sync"
+
+	"waller/internal/cache"
+	"waller/internal/config"
+
+	"github.com/gotk3/gotk3/gdk"
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// Grid thumbnails are shown thumbSize logical pixels wide in a 3:2 box and
+// rendered at the window's scale factor, so they stay sharp on HiDPI screens
+// (GTK main thread only).
+var (
+	thumbSize  = 150
+	thumbScale = 1
+	zoomLevels []int
+)
+
+// thumbWidth returns the pixel width of grid thumbnails at the current zoom and scale.
+func thumbWidth() int {
+	return thumbSize * thumbScale
+}
+
+// newZoomControls builds the buttons that step through the configured
+// thumbnail sizes. Changes reload the grid thumbnails and are saved to cfg.
+func newZoomControls(cfg *config.Config) (*gtk.Button, *gtk.Button) {
+	zoomLevels = cfg.ZoomLevels()
+	thumbSize = cfg.Zoom()
+
+	outBtn, _ := gtk.ButtonNewWithLabel("−")
+	outBtn.SetTooltipText("Smaller thumbnails")
+	inBtn, _ := gtk.ButtonNewWithLabel("+")
+	inBtn.SetTooltipText("Larger thumbnails")
+
+	update := func() {
+		i := slices.Index(zoomLevels, thumbSize)
+		outBtn.SetSensitive(i > 0)
+		inBtn.SetSensitive(i < len(zoomLevels)-1)
+	}
+	step := func(delta int) {
+		i := slices.Index(zoomLevels, thumbSize) + delta
+		if i < 0 || i >= len(zoomLevels) {
+			return
+		}
+		thumbSize = zoomLevels[i]
+		cfg.ThumbnailSize = thumbSize
+		cfg.Save()
+		update()
+		reloadThumbnails()
+	}
+	outBtn.Connect("clicked", func() { step(-1) })
+	inBtn.Connect("clicked", func() { step(1) })
+	update()
+
+	return outBtn, inBtn
+}
+
+// trackScale follows the scale factor of win, reloading the grid thumbnails
+// when the window moves to a screen with another scale.
+func trackScale(win *gtk.Window) {
+	thumbScale = max(win.GetScaleFactor(), 1)
+	win.Connect("notify::scale-factor", func() {
+		scale := max(win.GetScaleFactor(), 1)
+		if scale != thumbScale {
+			thumbScale = scale
+			reloadThumbnails()
+		}
+	})
+}
+
+// reloadThumbnails generates thumbnails of the current width for every shown
+// wallpaper off the main thread, then swaps them into the grid.
+func reloadThumbnails() {
+	paths := make([]string, 0, len(globalItems))
+	for path := range globalItems {
+		paths = append(paths, path)
+	}
+	gen, width := loadGeneration, thumbWidth()
+
+	go func() {
+		forEach(paths, func(path string) {
+			if _, err := cache.GetThumbnail(path, width, false); err != nil {
+				slog.Debug("Could not generate thumbnail", "path", path, "error", err)
+			}
+		})
+		if err := cache.SaveMeta(); err != nil {
+			slog.Warn("Failed to save thumbnail metadata", "error", err)
+		}
+
+		glib.IdleAdd(func() bool {
+			if gen != loadGeneration || width != thumbWidth() {
+				return false // Superseded by a reload or another zoom
+			}
+			for path, item := range globalItems {
+				setThumbnail(item.image, path)
+			}
+			return false // Run once
+		})
+	}()
+}
+
+// forEach calls fn for every path on a bounded pool of workers and waits for them.
+func forEach(paths []string, fn func(path string)) {
+	numWorkers := runtime.NumCPU()
+	jobs := make(chan string, numWorkers)
+
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				fn(path)
+			}
+		}()
+	}
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// thumbnailImage returns an image widget showing the cached thumbnail of path.
+func thumbnailImage(path string) *gtk.Image {
+	img, _ := gtk.ImageNew()
+	setThumbnail(img, path)
+	return img
+}
+
+// setThumbnail shows the cached thumbnail of path in img at the current zoom,
+// with one image pixel per device pixel.
+func setThumbnail(img *gtk.Image, path string) {
+	var pixbuf *gdk.Pixbuf
+	data, err := cache.GetThumbnail(path, thumbWidth(), true)
+	if err == nil {
+		pixbuf, err = pixbufFromData(data, thumbWidth(), thumbWidth()*2/3)
+	} else {
+		// Fallback to full image
+		pixbuf, err = gdk.PixbufNewFromFileAtScale(path, thumbWidth(), thumbWidth()*2/3, true)
+	}
+	if err != nil {
+		img.Clear()
+		return
+	}
+	// The pixbuf is released by its finalizer once the surface holds the pixels
+	surface, err := gdk.CairoSurfaceCreateFromPixbuf(pixbuf, thumbScale, nil)
+	if err != nil {
+		img.Clear()
+		return
+	}
+	img.SetFromSurface(surface)
+}
@@ -0,0 +1,432 @@
+// Package index keeps a persistent record of image metadata between runs.
+// It is stored as JSON in the waller cache directory and updated incrementally:
+// a file is only re-read when its size or modification time changes.
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"waller/internal/anim"
+	"waller/internal/convert"
+	"waller/internal/vfs"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Entry describes one indexed image.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Format  string    `json:"format"`
+	// Hash is the hex SHA-256 of the file content. It survives renames.
+	Hash string `json:"hash"`
+	// Frames is the number of animation frames, 1 for still images.
+	// Entries written before it existed have 0 and are re-read once.
+	Frames int `json:"frames"`
+}
+
+// Animated reports whether the image has more than one frame.
+func (e Entry) Animated() bool {
+	return e.Frames > 1
+}
+
+// Index is a thread-safe, persistent map of image path to Entry.
+type Index struct {
+	path string
+
+	mu      sync.RWMutex
+	entries map[string]Entry
+	dirty   bool
+	// removed holds the paths dropped since the last save, so merging with
+	// what other processes saved does not bring them back.
+	removed map[string]bool
+
+	saveMu sync.Mutex
+}
+
+// DefaultPath returns the location of the index in the user's cache directory.
+func DefaultPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "waller", "index.json"), nil
+}
+
+// Open loads the index from its default location.
+func Open() (*Index, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(path)
+}
+
+// OpenFile loads the index stored at path. A missing file yields an empty index.
+func OpenFile(path string) (*Index, error) {
+	idx := &Index{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// A corrupt index is only a cache; start over instead of failing
+		return idx, nil
+	}
+	for _, e := range entries {
+		idx.entries[e.Path] = e
+	}
+
+	return idx, nil
+}
+
+// Get returns the entry for path, if indexed.
+func (idx *Index) Get(path string) (Entry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	e, ok := idx.entries[path]
+	return e, ok
+}
+
+// Entries returns a snapshot of all indexed entries.
+func (idx *Index) Entries() []Entry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entries := make([]Entry, 0, len(idx.entries))
+	for _, e := range idx.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// ByHash groups indexed paths by content hash.
+func (idx *Index) ByHash() map[string][]string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	groups := make(map[string][]string)
+	for _, e := range idx.entries {
+		groups[e.Hash] = append(groups[e.Hash], e.Path)
+	}
+	return groups
+}
+
+// SortLargestFirst orders paths by resolution, then file size, largest first.
+// Paths missing from the index sort last.
+func (idx *Index) SortLargestFirst(paths []string) {
+	slices.SortStableFunc(paths, func(a, b string) int {
+		ea, _ := idx.Get(a)
+		eb, _ := idx.Get(b)
+		if c := cmp.Compare(eb.Width*eb.Height, ea.Width*ea.Height); c != 0 {
+			return c
+		}
+		return cmp.Compare(eb.Size, ea.Size)
+	})
+}
+
+// Update indexes paths, re-reading only files that are new or whose size or
+// modification time changed. Files that cannot be read are dropped from the index.
+// It returns how many entries were added or refreshed.
+func (idx *Index) Update(paths []string) int {
+	jobs := make(chan string)
+	var (
+		wg      sync.WaitGroup
+		countMu sync.Mutex
+		count   int
+	)
+
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				if idx.Refresh(path) {
+					countMu.Lock()
+					count++
+					countMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		jobs <- path
+	}
+	close(jobs)
+	wg.Wait()
+
+	return count
+}
+
+// Refresh brings the entry for path up to date and reports whether it was rewritten.
+func (idx *Index) Refresh(path string) bool {
+	info, err := vfs.Stat(path)
+	if err != nil {
+		idx.Remove(path)
+		return false
+	}
+
+	if e, ok := idx.Get(path); ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) && e.Frames > 0 {
+		return false
+	}
+
+	e, err := readEntry(path, info)
+	if err != nil {
+		idx.Remove(path)
+		return false
+	}
+
+	idx.mu.Lock()
+	idx.entries[path] = e
+	delete(idx.removed, path)
+	idx.dirty = true
+	idx.mu.Unlock()
+	return true
+}
+
+// Remove drops paths from the index.
+func (idx *Index) Remove(paths ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, path := range paths {
+		idx.remove(path)
+	}
+}
+
+// remove drops path and remembers it until the next save. The caller must hold mu.
+func (idx *Index) remove(path string) {
+	if _, ok := idx.entries[path]; ok {
+		delete(idx.entries, path)
+		idx.dirty = true
+	}
+	if idx.removed == nil {
+		idx.removed = make(map[string]bool)
+	}
+	idx.removed[path] = true
+}
+
+// merge adds entries to dst, except the removed paths. Of two entries for one
+// path the one describing the newer file wins.
+func merge(dst map[string]Entry, entries []Entry, removed map[string]bool) {
+	for _, e := range entries {
+		if cur, ok := dst[e.Path]; removed[e.Path] || (ok && !e.ModTime.After(cur.ModTime)) {
+			continue
+		}
+		dst[e.Path] = e
+	}
+}
+
+// Prune drops entries whose files no longer exist and returns how many were removed.
+func (idx *Index) Prune() int {
+	var missing []string
+	for _, e := range idx.Entries() {
+		if _, err := vfs.Stat(e.Path); os.IsNotExist(err) {
+			missing = append(missing, e.Path)
+		}
+	}
+	idx.Remove(missing...)
+	return len(missing)
+}
+
+// Save writes the index to disk if it changed since it was loaded or last saved.
+// The file is replaced atomically so a crash never leaves a half-written index,
+// and merged with what other processes saved meanwhile, whose entries are
+// then found by this index too.
+func (idx *Index) Save() error {
+	idx.saveMu.Lock()
+	defer idx.saveMu.Unlock()
+
+	idx.mu.Lock()
+	if !idx.dirty {
+		idx.mu.Unlock()
+		return nil
+	}
+	entries := maps.Clone(idx.entries)
+	removed := idx.removed
+	// Changes made while writing mark the index dirty again
+	idx.dirty, idx.removed = false, nil
+	idx.mu.Unlock()
+
+	saved, err := idx.write(entries, removed)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err != nil {
+		idx.dirty = true
+		for path := range removed {
+			if _, ok := idx.entries[path]; !ok {
+				idx.remove(path)
+			}
+		}
+		return err
+	}
+	merge(idx.entries, saved, idx.removed)
+	return nil
+}
+
+// write replaces the index file with entries, merged with those other
+// processes saved except the removed paths, and returns what it wrote.
+// Saves take a lock on a file next to the index, so none is lost.
+func (idx *Index) write(entries map[string]Entry, removed map[string]bool) ([]Entry, error) {
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(idx.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close() // Releases the lock
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("lock index: %w", err)
+	}
+
+	var saved []Entry
+	if data, err := os.ReadFile(idx.path); err == nil && json.Unmarshal(data, &saved) == nil {
+		merge(entries, saved, removed)
+	}
+	all := slices.Collect(maps.Values(entries))
+	data, err := json.Marshal(all)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each save writes its own temporary file, so concurrent saves never mix
+	tmp, err := os.CreateTemp(filepath.Dir(idx.path), filepath.Base(idx.path)+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), idx.path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// readEntry decodes the image header of path and hashes its content.
+func readEntry(path string, info os.FileInfo) (Entry, error) {
+	f, err := openSeekable(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+
+	cfg, format, err := decodeConfig(f, path)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Entry{}, err
+	}
+	frames, err := anim.FrameCount(f, format)
+	if err != nil {
+		frames = 1 // Still decodable up to the damaged part
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Entry{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Entry{}, err
+	}
+
+	return Entry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Width:   cfg.Width,
+		Height:  cfg.Height,
+		Format:  format,
+		Hash:    hex.EncodeToString(h.Sum(nil)),
+		Frames:  max(frames, 1),
+	}, nil
+}
+
+// openSeekable opens the file or archive entry at path. Archive entries are
+// read into memory, since they cannot be rewound.
+func openSeekable(path string) (io.ReadSeekCloser, error) {
+	if !vfs.IsVirtual(path) {
+		return os.Open(path)
+	}
+	rc, err := vfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+// nopCloser adds a no-op Close to an in-memory reader.
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// decodeConfig reads the dimensions and format of the image in r. Formats
+// without a Go decoder are measured on their converted copy.
+func decodeConfig(r io.Reader, path string) (image.Config, string, error) {
+	if !convert.NeedsConversion(path) {
+		return image.DecodeConfig(r)
+	}
+
+	local, err := vfs.Extract(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	renderable, err := convert.Renderable(local)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	rf, err := os.Open(renderable)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer rf.Close()
+
+	cfg, _, err := image.DecodeConfig(rf)
+	return cfg, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."), err
+}
@@ -0,0 +1,161 @@
+package index
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePNG writes a blank PNG of the given size.
+func writePNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// TestUpdateIsIncremental verifies entries are filled in and only changed files are re-read.
+func TestUpdateIsIncremental(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	a := filepath.Join(tmpDir, "a.png")
+	b := filepath.Join(tmpDir, "b.png")
+	writePNG(t, a, 32, 18)
+	writePNG(t, b, 10, 10)
+
+	idx, err := OpenFile(filepath.Join(tmpDir, "index.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Act
+	first := idx.Update([]string{a, b})
+	second := idx.Update([]string{a, b})
+	writePNG(t, b, 20, 20)
+	os.Chtimes(b, time.Now(), time.Now().Add(time.Minute))
+	third := idx.Update([]string{a, b})
+
+	// Assert
+	if first != 2 || second != 0 || third != 1 {
+		t.Errorf("Expected 2, 0, 1 refreshed entries, got %d, %d, %d", first, second, third)
+	}
+	e, ok := idx.Get(a)
+	if !ok {
+		t.Fatalf("Expected %s to be indexed", a)
+	}
+	if e.Width != 32 || e.Height != 18 || e.Format != "png" || e.Hash == "" {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+	if e, _ := idx.Get(b); e.Width != 20 {
+		t.Errorf("Expected refreshed width 20, got %d", e.Width)
+	}
+}
+
+// TestSaveAndReopen verifies the index persists and prunes deleted files.
+func TestSaveAndReopen(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	indexPath := filepath.Join(tmpDir, "cache", "index.json")
+	a := filepath.Join(tmpDir, "a.png")
+	b := filepath.Join(tmpDir, "b.png")
+	writePNG(t, a, 4, 4)
+	writePNG(t, b, 4, 4)
+
+	idx, _ := OpenFile(indexPath)
+	idx.Update([]string{a, b})
+
+	// Act
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	os.Remove(b)
+	reopened, err := OpenFile(indexPath)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	pruned := reopened.Prune()
+
+	// Assert
+	if pruned != 1 || len(reopened.Entries()) != 1 {
+		t.Errorf("Expected 1 pruned and 1 remaining entry, got %d and %d", pruned, len(reopened.Entries()))
+	}
+	if groups := reopened.ByHash(); len(groups) != 1 {
+		t.Errorf("Expected 1 hash group, got %d", len(groups))
+	}
+}
+
+// TestSaveMerges verifies that two processes saving the index in turn keep
+// each other's entries and removals.
+func TestSaveMerges(t *testing.T) {
+	// Arrange: the GUI and a build opened the same index
+	tmpDir := t.TempDir()
+	indexPath := filepath.Join(tmpDir, "cache", "index.json")
+	a := filepath.Join(tmpDir, "a.png")
+	b := filepath.Join(tmpDir, "b.png")
+	c := filepath.Join(tmpDir, "c.png")
+	writePNG(t, a, 4, 4)
+	writePNG(t, b, 4, 4)
+	writePNG(t, c, 4, 4)
+	first, _ := OpenFile(indexPath)
+	first.Update([]string{a})
+	first.Save()
+	gui, _ := OpenFile(indexPath)
+	build, _ := OpenFile(indexPath)
+
+	// Act
+	build.Update([]string{b})
+	buildErr := build.Save()
+	gui.Update([]string{c})
+	gui.Remove(a)
+	guiErr := gui.Save()
+	reopened, _ := OpenFile(indexPath)
+
+	// Assert
+	if buildErr != nil || guiErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", buildErr, guiErr)
+	}
+	if _, ok := gui.Get(b); !ok {
+		t.Errorf("Expected the GUI to find the entry the build saved")
+	}
+	_, hasA := reopened.Get(a)
+	_, hasB := reopened.Get(b)
+	_, hasC := reopened.Get(c)
+	if hasA || !hasB || !hasC {
+		t.Errorf("Expected b and c saved and a removed, got %v, %v, %v", hasA, hasB, hasC)
+	}
+}
+
+// TestSaveRetriesAfterFailure verifies that a failed save keeps the changes
+// pending, so the next save writes them.
+func TestSaveRetriesAfterFailure(t *testing.T) {
+	// Arrange: the index directory is blocked by a file
+	tmpDir := t.TempDir()
+	blocker := filepath.Join(tmpDir, "cache")
+	a := filepath.Join(tmpDir, "a.png")
+	writePNG(t, a, 4, 4)
+	idx, _ := OpenFile(filepath.Join(blocker, "index.json"))
+	idx.Update([]string{a})
+	os.WriteFile(blocker, nil, 0644)
+
+	// Act
+	failErr := idx.Save()
+	os.Remove(blocker)
+	retryErr := idx.Save()
+	reopened, _ := OpenFile(filepath.Join(blocker, "index.json"))
+
+	// Assert
+	if failErr == nil {
+		t.Fatalf("Expected the blocked save to fail")
+	}
+	if retryErr != nil || len(reopened.Entries()) != 1 {
+		t.Errorf("Expected the retry to save 1 entry, got %d, %v", len(reopened.Entries()), retryErr)
+	}
+}
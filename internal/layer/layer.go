@@ -1,5 +1,7 @@
 // Package layer provides the Wayland wallpaper daemon using gtk-layer-shell.
-// It creates fullscreen windows on the background layer with CSS-scaled wallpapers.
+// It creates fullscreen windows on the background layer and shows wallpapers
+// rendered at each monitor's resolution, falling back to CSS scaling.
+// Animations play in an image widget covering the window.
 // Single daemon handles all monitors via one IPC socket.
 package layer
 
@@ -9,6 +11,20 @@ package layer
 #include <gtk/gtk.h>
 #include <gtk-layer-shell/gtk-layer-shell.h>
 
+// Per-window CSS providers to prevent memory leaks. Every live wallpaper
+// window has an entry, with a NULL provider until its first wallpaper.
+typedef struct {
+    GtkCssProvider *provider;
+} WindowData;
+
+static GHashTable *window_providers = NULL;
+
+void init_window_providers() {
+    if (window_providers == NULL) {
+        window_providers = g_hash_table_new(g_direct_hash, g_direct_equal);
+    }
+}
+
 // Helper to create a layer window for a specific monitor
 GtkWidget* create_wallpaper_window(int monitor_index) {
     GtkWidget *window = gtk_window_new(GTK_WINDOW_TOPLEVEL);
@@ -32,6 +48,12 @@ GtkWidget* create_wallpaper_window(int monitor_index) {
     GtkStyleContext *context = gtk_widget_get_style_context(window);
     gtk_style_context_add_class(context, "wallpaper");
 
+    // Animation frames are shown here, over the CSS background
+    gtk_container_add(GTK_CONTAINER(window), gtk_image_new());
+
+    // Register the window so updates queued for it can tell it still exists
+    g_hash_table_insert(window_providers, window, NULL);
+
     return window;
 }
 
@@ -41,20 +63,22 @@ int get_monitor_count() {
     return gdk_display_get_n_monitors(display);
 }
 
-// Per-window CSS providers to prevent memory leaks
-typedef struct {
-    GtkCssProvider *provider;
-} WindowData;
-
-static GHashTable *window_providers = NULL;
-
-void init_window_providers() {
-    if (window_providers == NULL) {
-        window_providers = g_hash_table_new(g_direct_hash, g_direct_equal);
+// Destroy a wallpaper window and its CSS provider
+void destroy_wallpaper_window(GtkWidget *window) {
+    GtkCssProvider *provider = g_hash_table_lookup(window_providers, window);
+    if (provider != NULL) {
+        g_object_unref(provider);
     }
+    g_hash_table_remove(window_providers, window);
+    gtk_widget_destroy(window);
 }
 
 void apply_css_to_window(GtkWidget* window, const char* css_data) {
+    // The window was destroyed after the update was queued
+    if (!g_hash_table_contains(window_providers, window)) {
+        return;
+    }
+
     GtkStyleContext *context = gtk_widget_get_style_context(window);
 
     // Get or create provider for this window
@@ -68,6 +92,9 @@ void apply_css_to_window(GtkWidget* window, const char* css_data) {
     gtk_css_provider_load_from_data(provider, css_data, -1, NULL);
     gtk_style_context_add_provider(context, GTK_STYLE_PROVIDER(provider), GTK_STYLE_PROVIDER_PRIORITY_USER);
     g_hash_table_insert(window_providers, window, provider);
+
+    // Uncover the new background
+    gtk_image_clear(GTK_IMAGE(gtk_bin_get_child(GTK_BIN(window))));
 }
 
 // Callback data for idle function
@@ -79,6 +106,7 @@ typedef struct {
 static gboolean idle_update_wallpaper(gpointer user_data) {
     IdleData *data = (IdleData *)user_data;
     apply_css_to_window(data->window, data->css_data);
+    g_object_unref(data->window);
     g_free(data->css_data);
     g_free(data);
     return G_SOURCE_REMOVE;
@@ -86,61 +114,400 @@ static gboolean idle_update_wallpaper(gpointer user_data) {
 
 void schedule_wallpaper_update(GtkWidget *window, const char *css_data) {
     IdleData *data = g_malloc(sizeof(IdleData));
-    data->window = window;
+    // Keep the widget alive, so a destroyed window is never mistaken for a new one
+    data->window = g_object_ref(window);
     data->css_data = g_strdup(css_data);
     g_idle_add(idle_update_wallpaper, data);
 }
+
+// Load an animation frame scaled to width x height, centred on a black
+// mon_width x mon_height surface. Returns NULL and sets error_message on failure.
+cairo_surface_t* load_frame(const char *path, int width, int height, int mon_width, int mon_height, char **error_message) {
+    GError *error = NULL;
+    GdkPixbuf *pixbuf = gdk_pixbuf_new_from_file_at_scale(path, width, height, FALSE, &error);
+    if (pixbuf == NULL) {
+        *error_message = g_strdup(error->message);
+        g_error_free(error);
+        return NULL;
+    }
+
+    cairo_surface_t *surface = cairo_image_surface_create(CAIRO_FORMAT_RGB24, mon_width, mon_height);
+    cairo_t *cr = cairo_create(surface);
+    cairo_set_source_rgb(cr, 0, 0, 0);
+    cairo_paint(cr);
+    gdk_cairo_set_source_pixbuf(cr, pixbuf, (mon_width - width) / 2.0, (mon_height - height) / 2.0);
+    cairo_paint(cr);
+    cairo_destroy(cr);
+    g_object_unref(pixbuf);
+    return surface;
+}
+
+// Callback data for showing a frame
+typedef struct {
+    GtkWidget *window;
+    cairo_surface_t *surface;
+} FrameData;
+
+static gboolean idle_show_frame(gpointer user_data) {
+    FrameData *data = (FrameData *)user_data;
+    if (g_hash_table_contains(window_providers, data->window)) {
+        // Frames are in device pixels; show them at the monitor's logical size
+        int scale = gtk_widget_get_scale_factor(data->window);
+        cairo_surface_set_device_scale(data->surface, scale, scale);
+        gtk_image_set_from_surface(GTK_IMAGE(gtk_bin_get_child(GTK_BIN(data->window))), data->surface);
+    }
+    g_object_unref(data->window);
+    cairo_surface_destroy(data->surface);
+    g_free(data);
+    return G_SOURCE_REMOVE;
+}
+
+void schedule_frame(GtkWidget *window, cairo_surface_t *surface) {
+    FrameData *data = g_malloc(sizeof(FrameData));
+    data->window = g_object_ref(window);
+    data->surface = cairo_surface_reference(surface);
+    g_idle_add(idle_show_frame, data);
+}
 */
 import "C"
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"image/png"
 	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
+	"waller/internal/anim"
+	"waller/internal/cache"
+	"waller/internal/config"
+	"waller/internal/fit"
 	"waller/internal/ipc"
+	"waller/internal/monitor"
+	"waller/internal/pixbuf"
+	"waller/internal/render"
+
+	"github.com/gotk3/gotk3/gdk"
 )
 
-// windows holds GTK window pointers for each monitor
-var windows []*C.GtkWidget
+// windows holds GTK window pointers for each monitor, and windowNames the
+// name of the monitor each is shown on. Both are guarded by playersMu and
+// rebuilt when monitors are plugged in or removed.
+var (
+	windows     []*C.GtkWidget
+	windowNames []string
+)
 
-// updateWallpaperCSS applies CSS to a window (for initial setup).
-func getWallpaperCSS(imagePath string) string {
+// players holds the stop channel of the animation running on each monitor.
+// playersMu also orders frame updates against new wallpapers, so a frame
+// scheduled by a stopping animation never lands after its replacement.
+var (
+	playersMu sync.Mutex
+	players   = make(map[int]chan struct{})
+	maxFPS    int
+)
+
+// monitors holds the pixel size of each monitor, in window order, and
+// wallpapers the image set on each. A still wallpaper is shown once its
+// rendition is ready, unless the monitor's generation has moved on to a newer
+// wallpaper. All three are guarded by playersMu.
+var (
+	monitors    []fit.Monitor
+	fitMode     = fit.Cover
+	generations = make(map[int]int)
+	wallpapers  = make(map[int]string)
+)
+
+// renderMu runs one render at a time, so the daemon holds at most one decoded
+// original in memory.
+var renderMu sync.Mutex
+
+// backgroundSizes maps fit modes to the CSS that scales images not rendered
+// for the monitor: animations until their frames are loaded, and originals
+// that failed to render.
+var backgroundSizes = map[fit.Mode]string{
+	fit.Cover:   "cover",
+	fit.Contain: "contain",
+	fit.Stretch: "100% 100%",
+	fit.Center:  "auto",
+}
+
+// renditionSize shows a rendition, which is already fitted to the monitor,
+// across the whole window. Its natural size is in device pixels, larger than
+// the window on scaled monitors, so the fit mode's CSS must not size it again.
+const renditionSize = "100% 100%"
+
+// getWallpaperCSS returns the CSS showing imagePath with the given background-size.
+func getWallpaperCSS(imagePath, size string) string {
 	return fmt.Sprintf(`
         .wallpaper {
             background-image: url("%s");
-            background-size: cover;
+            background-size: %s;
             background-repeat: no-repeat;
             background-position: center;
+            background-color: black;
         }
-    `, imagePath)
+    `, imagePath, size)
 }
 
-func updateWallpaperCSS(win *C.GtkWidget, imagePath string) {
-	cCss := C.CString(getWallpaperCSS(imagePath))
-	defer C.free(unsafe.Pointer(cCss))
-	C.apply_css_to_window(win, cCss)
-}
-
-func scheduleWallpaperUpdate(win *C.GtkWidget, imagePath string) {
-	cCss := C.CString(getWallpaperCSS(imagePath))
+func scheduleWallpaperUpdate(win *C.GtkWidget, imagePath, size string) {
+	cCss := C.CString(getWallpaperCSS(imagePath, size))
 	C.schedule_wallpaper_update(win, cCss)
 	C.free(unsafe.Pointer(cCss))
 }
 
 // applyToMonitor applies wallpaper to specified monitor or all monitors.
 func applyToMonitor(monitorIdx int, imagePath string) {
+	animated := anim.IsAnimated(imagePath)
+	playersMu.Lock()
+	nWindows := len(windows)
+	playersMu.Unlock()
+
 	if monitorIdx == -1 {
-		for _, win := range windows {
-			scheduleWallpaperUpdate(win, imagePath)
+		for i := range nWindows {
+			setWallpaper(i, imagePath, animated)
+		}
+	} else if monitorIdx >= 0 && monitorIdx < nWindows {
+		setWallpaper(monitorIdx, imagePath, animated)
+	}
+}
+
+// setWallpaper shows imagePath on monitor i, stopping the animation running
+// there. Animated images show their first frame until playback starts; still
+// images are shown once rendered for the monitor.
+func setWallpaper(i int, imagePath string, animated bool) {
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	// The monitor was removed meanwhile
+	if i >= len(windows) {
+		return
+	}
+
+	if stop, ok := players[i]; ok {
+		close(stop)
+		delete(players, i)
+	}
+	generations[i]++
+	wallpapers[i] = imagePath
+
+	if animated {
+		scheduleWallpaperUpdate(windows[i], imagePath, backgroundSizes[fitMode])
+		stop := make(chan struct{})
+		players[i] = stop
+		var mon fit.Monitor
+		if i < len(monitors) {
+			mon = monitors[i]
+		}
+		go play(windows[i], imagePath, mon, stop)
+		return
+	}
+	go showRendered(i, generations[i], imagePath)
+}
+
+// showRendered shows the rendition of imagePath for monitor i, or the original
+// if it cannot be rendered, unless another wallpaper was set there meanwhile.
+func showRendered(i, gen int, imagePath string) {
+	playersMu.Lock()
+	var mon fit.Monitor
+	if i < len(monitors) {
+		mon = monitors[i]
+	}
+	playersMu.Unlock()
+
+	path, size := imagePath, backgroundSizes[fitMode]
+	if mon.Width > 0 && mon.Height > 0 {
+		renderMu.Lock()
+		rendered, err := render.Render(imagePath, mon, fitMode)
+		// Return the decoded original to the OS instead of holding it until
+		// the heap grows again
+		debug.FreeOSMemory()
+		renderMu.Unlock()
+		if err != nil {
+			slog.Warn("Failed to render wallpaper, scaling the original", "path", imagePath, "error", err)
+		} else {
+			path, size = rendered, renditionSize
+		}
+	}
+
+	playersMu.Lock()
+	defer playersMu.Unlock()
+	if generations[i] == gen {
+		scheduleWallpaperUpdate(windows[i], path, size)
+	}
+}
+
+// watchMonitors rebuilds the windows when a monitor is plugged in or removed.
+// GDK renumbers the monitors then, so every window is replaced by one for the
+// monitor now at its index.
+func watchMonitors() {
+	display, err := gdk.DisplayGetDefault()
+	if err != nil {
+		return
+	}
+	display.Connect("monitor-added", rebuildWindows)
+	display.Connect("monitor-removed", rebuildWindows)
+}
+
+// rebuildWindows replaces the windows with one per current monitor, and shows
+// each monitor the wallpaper it had before. A monitor is recognised by its
+// name; a new one gets the wallpaper of the first monitor. It runs on the
+// main thread.
+func rebuildWindows() {
+	mons := monitor.Geometries()
+	names := monitor.Names()
+
+	playersMu.Lock()
+	shown := carryOver(windowNames, names, wallpapers)
+	for i, stop := range players {
+		close(stop)
+		delete(players, i)
+	}
+	// Drop renditions still being prepared for the old windows
+	for i, win := range windows {
+		generations[i]++
+		C.destroy_wallpaper_window(win)
+	}
+	windows = createWindows(len(names))
+	monitors, windowNames = mons, names
+	wallpapers = make(map[int]string)
+	playersMu.Unlock()
+
+	slog.Info("Monitors changed", "monitors", len(names))
+	// Telling animations from still images reads the files, so keep it off the main thread
+	go func() {
+		for i, path := range shown {
+			applyToMonitor(i, path)
+		}
+	}()
+}
+
+// carryOver returns the wallpaper for each of the monitors newNames, given the
+// wallpapers shown on the monitors oldNames. A monitor keeps its wallpaper if
+// it was connected before; otherwise it gets the first old monitor's.
+func carryOver(oldNames, newNames []string, shown map[int]string) map[int]string {
+	fallback := ""
+	for i := range oldNames {
+		if path, ok := shown[i]; ok {
+			fallback = path
+			break
+		}
+	}
+
+	claimed := make([]bool, len(oldNames))
+	result := make(map[int]string)
+	for i, name := range newNames {
+		path := fallback
+		for j, old := range oldNames {
+			if !claimed[j] && old == name {
+				claimed[j] = true
+				path = shown[j]
+				break
+			}
+		}
+		if path != "" {
+			result[i] = path
+		}
+	}
+	return result
+}
+
+// createWindows creates and shows a wallpaper window on each of the first n monitors.
+func createWindows(n int) []*C.GtkWidget {
+	wins := make([]*C.GtkWidget, n)
+	for i := range n {
+		wins[i] = C.create_wallpaper_window(C.int(i))
+		C.gtk_widget_show_all(wins[i])
+	}
+	return wins
+}
+
+// play cycles through the frames of the animation at imagePath on mon until stopped.
+func play(win *C.GtkWidget, imagePath string, mon fit.Monitor, stop chan struct{}) {
+	// Rendering the frames can take a while the first time an animation is shown
+	frames, err := anim.Frames(imagePath, maxFPS)
+	if err != nil {
+		slog.Warn("Failed to decode animation", "path", imagePath, "error", err)
+		return
+	}
+	surfaces, err := loadFrames(frames, mon)
+	if err != nil {
+		slog.Warn("Failed to load animation frames", "path", imagePath, "error", err)
+		return
+	}
+	// The window keeps its own reference to the frame it shows
+	defer destroySurfaces(surfaces)
+
+	for {
+		for i, frame := range frames {
+			playersMu.Lock()
+			select {
+			case <-stop:
+				playersMu.Unlock()
+				return
+			default:
+			}
+			C.schedule_frame(win, surfaces[i])
+			playersMu.Unlock()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(frame.Delay):
+			}
 		}
-	} else if monitorIdx >= 0 && monitorIdx < len(windows) {
-		scheduleWallpaperUpdate(windows[monitorIdx], imagePath)
+	}
+}
+
+// loadFrames decodes the frames once, fitted to mon, so playback only swaps
+// finished surfaces in. Each takes the monitor's full size in memory. An
+// unknown monitor size shows the frames unscaled.
+func loadFrames(frames []anim.Frame, mon fit.Monitor) ([]*C.cairo_surface_t, error) {
+	if len(frames) == 0 {
+		return nil, errors.New("animation has no frames")
+	}
+	f, err := os.Open(frames[0].Path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := png.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if mon.Width <= 0 || mon.Height <= 0 {
+		mon = fit.Monitor{Width: cfg.Width, Height: cfg.Height}
+	}
+	w, h := fit.Scaled(cfg.Width, cfg.Height, mon, fitMode)
+
+	surfaces := make([]*C.cairo_surface_t, 0, len(frames))
+	for _, frame := range frames {
+		cPath := C.CString(frame.Path)
+		var cErr *C.char
+		surface := C.load_frame(cPath, C.int(w), C.int(h), C.int(mon.Width), C.int(mon.Height), &cErr)
+		C.free(unsafe.Pointer(cPath))
+		if surface == nil {
+			destroySurfaces(surfaces)
+			err := errors.New(C.GoString(cErr))
+			C.g_free(C.gpointer(unsafe.Pointer(cErr)))
+			return nil, err
+		}
+		surfaces = append(surfaces, surface)
+	}
+	return surfaces, nil
+}
+
+// destroySurfaces releases the frames loaded by loadFrames.
+func destroySurfaces(surfaces []*C.cairo_surface_t) {
+	for _, surface := range surfaces {
+		C.cairo_surface_destroy(surface)
 	}
 }
 
@@ -150,16 +517,21 @@ func RunDaemon(imagePath string, _ int) {
 	C.gtk_init(nil, nil)
 	C.init_window_providers()
 
+	if cfg, err := config.Load(); err == nil {
+		maxFPS = cfg.AnimationFPS
+		fitMode = fit.ParseMode(cfg.FitMode)
+	}
+	cache.SetScaledDecoder(pixbuf.DecodeScaled)
+
 	// Create windows for all monitors
 	nMonitors := int(C.get_monitor_count())
-	windows = make([]*C.GtkWidget, nMonitors)
+	playersMu.Lock()
+	windows = createWindows(nMonitors)
+	monitors, windowNames = monitor.Geometries(), monitor.Names()
+	playersMu.Unlock()
+	watchMonitors()
 
-	for i := range nMonitors {
-		win := C.create_wallpaper_window(C.int(i))
-		windows[i] = win
-		updateWallpaperCSS(win, imagePath)
-		C.gtk_widget_show_all(win)
-	}
+	applyToMonitor(-1, imagePath)
 
 	slog.Info("Daemon started", "monitors", nMonitors, "socket", ipc.SocketPath)
 
@@ -10,13 +10,36 @@ import (
 	"os/exec"
 	"time"
 
+	"waller/internal/convert"
 	"waller/internal/ipc"
+	"waller/internal/remote"
+	"waller/internal/vfs"
 )
 
 // ApplyWallpaper sets the wallpaper on the specified monitor index (-1 for All).
-func ApplyWallpaper(path string, monitorIndex int) {
+// path may also be an http(s) URL, which is downloaded to the cache first.
+// Formats the daemon cannot render are converted first.
+func ApplyWallpaper(path string, monitorIndex int) error {
+	if remote.IsURL(path) {
+		local, err := remote.Fetch(path)
+		if err != nil {
+			return err
+		}
+		path = local
+	}
+
+	// Archive entries are extracted to the cache for the daemon
+	path, err := vfs.Extract(path)
+	if err != nil {
+		return err
+	}
+	path, err = convert.Renderable(path)
+	if err != nil {
+		return err
+	}
 	ensureDaemonRunning(path)
 	sendIPCUpdate(monitorIndex, path)
+	return nil
 }
 
 // ensureDaemonRunning checks if daemon is running, spawns if not.
@@ -0,0 +1,156 @@
+// Package metadata stores the user's favorites and tags for wallpapers.
+// Records are keyed by content hash so they survive renames and moves,
+// and are kept as JSON next to the config file.
+package metadata
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Record holds the user's annotations for one image.
+type Record struct {
+	Favorite bool     `json:"favorite,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// HasAnyTag reports whether the record carries at least one of tags.
+func (r Record) HasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(r.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is a thread-safe, persistent map of content hash to Record.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// DefaultPath returns the location of the store in the user's config directory.
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "waller", "metadata.json"), nil
+}
+
+// Open loads the store from its default location.
+func Open() (*Store, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return OpenFile(path)
+}
+
+// OpenFile loads the store kept at path. A missing file yields an empty store.
+// Unlike the caches, a corrupt file is an error: it holds user data.
+func OpenFile(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		records: make(map[string]Record),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the record for the image with the given content hash.
+func (s *Store) Get(hash string) Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[hash]
+}
+
+// SetFavorite marks or unmarks an image as favorite and saves the store.
+func (s *Store) SetFavorite(hash string, favorite bool) error {
+	return s.update(hash, func(r *Record) {
+		r.Favorite = favorite
+	})
+}
+
+// SetTags replaces the tags of an image and saves the store.
+// Tags are normalized with NormalizeTags.
+func (s *Store) SetTags(hash string, tags []string) error {
+	return s.update(hash, func(r *Record) {
+		r.Tags = NormalizeTags(tags)
+	})
+}
+
+// update applies fn to the record of hash, dropping records that become empty, and saves.
+func (s *Store) update(hash string, fn func(r *Record)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.records[hash]
+	fn(&r)
+	if !r.Favorite && len(r.Tags) == 0 {
+		delete(s.records, hash)
+	} else {
+		s.records[hash] = r
+	}
+	return s.save()
+}
+
+// save writes the store to disk atomically. The caller must hold mu.
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	// Each save writes its own temporary file, so the GUI and a CLI command
+	// saving at once cannot mix them
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// ParseTags splits a comma-separated list of tags and normalizes it.
+func ParseTags(list string) []string {
+	return NormalizeTags(strings.Split(list, ","))
+}
+
+// NormalizeTags trims and lowercases tags, drops empty ones and duplicates, and sorts them.
+func NormalizeTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
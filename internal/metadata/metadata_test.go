@@ -0,0 +1,40 @@
+package metadata
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestStorePersists verifies favorites and tags survive a reopen and empty records are dropped.
+func TestStorePersists(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "waller", "metadata.json")
+	s, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Act
+	s.SetFavorite("aaa", true)
+	s.SetTags("aaa", []string{" Nature", "dark", "nature", ""})
+	s.SetTags("bbb", []string{"city"})
+	s.SetTags("bbb", nil)
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	r := reopened.Get("aaa")
+	if !r.Favorite || !slices.Equal(r.Tags, []string{"dark", "nature"}) {
+		t.Errorf("Unexpected record: %+v", r)
+	}
+	if len(reopened.records) != 1 {
+		t.Errorf("Expected the emptied record to be dropped, got %v", reopened.records)
+	}
+	if !r.HasAnyTag(ParseTags("city, Dark")) || r.HasAnyTag([]string{"city"}) {
+		t.Errorf("Unexpected HasAnyTag result for %v", r.Tags)
+	}
+}
@@ -0,0 +1,67 @@
+// Package monitor reads the geometry of connected monitors from GDK.
+// GTK must be initialised before any function is called.
+package monitor
+
+import (
+	"strings"
+
+	"github.com/gotk3/gotk3/gdk"
+
+	"waller/internal/fit"
+)
+
+// Geometries returns the physical pixel size of every monitor, in GDK index order.
+func Geometries() []fit.Monitor {
+	display, err := gdk.DisplayGetDefault()
+	if err != nil {
+		return nil
+	}
+
+	nMonitors := display.GetNMonitors()
+	mons := make([]fit.Monitor, 0, nMonitors)
+	for i := range nMonitors {
+		mon, err := display.GetMonitor(i)
+		if err != nil {
+			continue
+		}
+		// Geometry is in logical pixels; the scale factor converts to device pixels
+		geom := mon.GetGeometry()
+		scale := mon.GetScaleFactor()
+		mons = append(mons, fit.Monitor{
+			Width:  geom.GetWidth() * scale,
+			Height: geom.GetHeight() * scale,
+		})
+	}
+	return mons
+}
+
+// Names returns the manufacturer and model of every monitor, in GDK index
+// order. They tell monitors apart across hotplugs, when indices shift; an
+// unknown monitor is named "".
+func Names() []string {
+	display, err := gdk.DisplayGetDefault()
+	if err != nil {
+		return nil
+	}
+
+	nMonitors := display.GetNMonitors()
+	names := make([]string, nMonitors)
+	for i := range nMonitors {
+		if mon, err := display.GetMonitor(i); err == nil {
+			names[i] = strings.TrimSpace(mon.GetManufacturer() + " " + mon.GetModel())
+		}
+	}
+	return names
+}
+
+// Targets returns the monitors that a wallpaper applied to monitorIndex is shown on.
+// Index -1 means all monitors; an out-of-range index yields none.
+func Targets(mons []fit.Monitor, monitorIndex int) []fit.Monitor {
+	if monitorIndex == -1 {
+		return mons
+	}
+	if monitorIndex >= 0 && monitorIndex < len(mons) {
+		return mons[monitorIndex : monitorIndex+1]
+	}
+	return nil
+}
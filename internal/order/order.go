@@ -0,0 +1,173 @@
+// Package order sorts wallpaper lists by name, modification time, file size,
+// resolution, dominant hue or at random, in either direction.
+package order
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Key is the attribute wallpapers are sorted by.
+type Key string
+
+// Sort keys, as used in the config file and the --sort flag.
+const (
+	Name       Key = "name" // Natural order, so img2 sorts before img10
+	Modified   Key = "mtime"
+	Size       Key = "size"
+	Resolution Key = "resolution"
+	Hue        Key = "hue"
+	Random     Key = "random"
+)
+
+// Keys lists every sort key in the order menus show them.
+var Keys = []Key{Name, Modified, Size, Resolution, Hue, Random}
+
+// Order is a sort key and direction.
+type Order struct {
+	Key        Key
+	Descending bool
+}
+
+// Parse reads an order written as "key" or "key:desc" (also "key:asc").
+func Parse(s string) (Order, error) {
+	key, dir, _ := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	o := Order{Key: Key(key)}
+	if !slices.Contains(Keys, o.Key) {
+		return Order{}, fmt.Errorf("unknown sort key %q", key)
+	}
+	switch dir {
+	case "", "asc":
+	case "desc":
+		o.Descending = true
+	default:
+		return Order{}, fmt.Errorf("unknown sort direction %q", dir)
+	}
+	return o, nil
+}
+
+// String formats o the way Parse reads it.
+func (o Order) String() string {
+	if o.Descending {
+		return string(o.Key) + ":desc"
+	}
+	return string(o.Key)
+}
+
+// Info holds the attributes of a wallpaper that sorting may need.
+// Fields that are unknown are left zero and sort first.
+type Info struct {
+	ModTime time.Time
+	Size    int64
+	Width   int
+	Height  int
+	Hue     float64 // palette.SortKey of the dominant colors
+}
+
+// Sort orders paths in place. info is called once per path, so it may do I/O.
+// Ties, and the Name key, use natural order of the full path.
+func Sort(paths []string, o Order, info func(path string) Info) {
+	if o.Key == Random {
+		rand.Shuffle(len(paths), func(i, j int) {
+			paths[i], paths[j] = paths[j], paths[i]
+		})
+		return
+	}
+
+	type item struct {
+		path string
+		info Info
+	}
+	items := make([]item, len(paths))
+	for i, path := range paths {
+		items[i] = item{path: path}
+		if o.Key != Name {
+			items[i].info = info(path)
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b item) int {
+		c := compare(o.Key, a.info, b.info)
+		if c == 0 {
+			c = Natural(a.path, b.path)
+			if o.Key != Name {
+				return c // Ties stay in name order either way
+			}
+		}
+		if o.Descending {
+			return -c
+		}
+		return c
+	})
+
+	for i, it := range items {
+		paths[i] = it.path
+	}
+}
+
+// compare orders two wallpapers by key.
+func compare(key Key, a, b Info) int {
+	switch key {
+	case Modified:
+		return a.ModTime.Compare(b.ModTime)
+	case Size:
+		return cmp.Compare(a.Size, b.Size)
+	case Resolution:
+		return cmp.Compare(a.Width*a.Height, b.Width*b.Height)
+	case Hue:
+		return cmp.Compare(a.Hue, b.Hue)
+	}
+	return 0
+}
+
+// Natural compares strings case-insensitively, treating runs of digits as
+// numbers: "img2.jpg" sorts before "img10.jpg".
+func Natural(a, b string) int {
+	sa, sb := a, b
+	for sa != "" && sb != "" {
+		if isDigit(sa[0]) && isDigit(sb[0]) {
+			na, nb := digitRun(sa), digitRun(sb)
+			ta, tb := strings.TrimLeft(sa[:na], "0"), strings.TrimLeft(sb[:nb], "0")
+			// A longer number without leading zeros is larger
+			if c := cmp.Compare(len(ta), len(tb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			sa, sb = sa[na:], sb[nb:]
+			continue
+		}
+
+		ra, wa := utf8.DecodeRuneInString(sa)
+		rb, wb := utf8.DecodeRuneInString(sb)
+		if c := cmp.Compare(unicode.ToLower(ra), unicode.ToLower(rb)); c != 0 {
+			return c
+		}
+		sa, sb = sa[wa:], sb[wb:]
+	}
+	if c := cmp.Compare(len(sa), len(sb)); c != 0 {
+		return c
+	}
+	// Equal apart from case or leading zeros; keep the order deterministic
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// digitRun returns the length of the run of digits at the start of s.
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package order
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestNatural verifies numbers inside names are compared by value.
+func TestNatural(t *testing.T) {
+	// Arrange
+	paths := []string{"img10.jpg", "IMG2.jpg", "img1.jpg", "a/img3.jpg", "img02.jpg", "img2.png"}
+
+	// Act
+	slices.SortFunc(paths, Natural)
+
+	// Assert
+	want := []string{"a/img3.jpg", "img1.jpg", "IMG2.jpg", "img02.jpg", "img2.png", "img10.jpg"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+}
+
+// TestSort verifies each key and direction, with ties in name order.
+func TestSort(t *testing.T) {
+	// Arrange
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	infos := map[string]Info{
+		"b10.jpg": {ModTime: base.Add(time.Hour), Size: 300, Width: 1920, Height: 1080, Hue: 200},
+		"b2.jpg":  {ModTime: base, Size: 100, Width: 3840, Height: 2160, Hue: 10},
+		"a.jpg":   {ModTime: base.Add(2 * time.Hour), Size: 100, Width: 1280, Height: 720, Hue: 120},
+	}
+	lookup := func(path string) Info { return infos[path] }
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"name", []string{"a.jpg", "b2.jpg", "b10.jpg"}},
+		{"name:desc", []string{"b10.jpg", "b2.jpg", "a.jpg"}},
+		{"mtime", []string{"b2.jpg", "b10.jpg", "a.jpg"}},
+		{"size", []string{"a.jpg", "b2.jpg", "b10.jpg"}},
+		{"size:desc", []string{"b10.jpg", "a.jpg", "b2.jpg"}},
+		{"resolution:desc", []string{"b2.jpg", "b10.jpg", "a.jpg"}},
+		{"hue", []string{"b2.jpg", "a.jpg", "b10.jpg"}},
+	}
+
+	for _, tt := range tests {
+		o, err := Parse(tt.order)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.order, err)
+		}
+		paths := []string{"b10.jpg", "b2.jpg", "a.jpg"}
+
+		// Act
+		Sort(paths, o, lookup)
+
+		// Assert
+		if !slices.Equal(paths, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.order, tt.want, paths)
+		}
+		if o.String() != tt.order {
+			t.Errorf("Expected %q to round-trip, got %q", tt.order, o.String())
+		}
+	}
+}
+
+// TestParseErrors verifies unknown keys and directions are rejected.
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"colour", "name:up", ""} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected an error", s)
+		}
+	}
+}
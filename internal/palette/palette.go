@@ -0,0 +1,197 @@
+// Package palette extracts dominant colors from images and names their hues,
+// so wallpapers can be browsed and picked by color.
+package palette
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Color is an sRGB color that marshals to "#rrggbb".
+type Color struct {
+	R, G, B uint8
+}
+
+// Hues lists the color names that Color.Name returns, in hue order.
+var Hues = []string{"red", "orange", "yellow", "green", "cyan", "blue", "purple", "pink", "white", "gray", "black"}
+
+// String returns the color as "#rrggbb".
+func (c Color) String() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Color) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	return err
+}
+
+// HSV returns hue in degrees [0, 360) and saturation and value in [0, 1].
+func (c Color) HSV() (h, s, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC := max(r, g, b)
+	minC := min(r, g, b)
+	delta := maxC - minC
+
+	v = maxC
+	if maxC > 0 {
+		s = delta / maxC
+	}
+	if delta == 0 {
+		return 0, s, v
+	}
+
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/delta, 6)
+	case g:
+		h = (b-r)/delta + 2
+	default:
+		h = (r-g)/delta + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, v
+}
+
+// IsNeutral reports whether the color is too dark or unsaturated to have a meaningful hue.
+func (c Color) IsNeutral() bool {
+	_, s, v := c.HSV()
+	return v < 0.2 || s < 0.2
+}
+
+// Name returns the hue bucket of the color, one of Hues.
+func (c Color) Name() string {
+	h, s, v := c.HSV()
+	switch {
+	case v < 0.2:
+		return "black"
+	case s < 0.2 && v > 0.85:
+		return "white"
+	case s < 0.2:
+		return "gray"
+	case h < 15 || h >= 345:
+		return "red"
+	case h < 45:
+		return "orange"
+	case h < 70:
+		return "yellow"
+	case h < 165:
+		return "green"
+	case h < 195:
+		return "cyan"
+	case h < 255:
+		return "blue"
+	case h < 290:
+		return "purple"
+	default:
+		return "pink"
+	}
+}
+
+// bin accumulates the pixels that fall into one quantized color cell.
+type bin struct {
+	r, g, b, count int
+}
+
+// Extract returns up to n dominant colors of img, most frequent first.
+// Pixels are quantized to 4 bits per channel; each result is the mean of its cell.
+// It is meant for thumbnails, where visiting every pixel is cheap.
+func Extract(img image.Image, n int) []Color {
+	bins := make(map[int]*bin)
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue // Skip transparent pixels
+			}
+			r8, g8, b8 := int(r>>8), int(g>>8), int(b>>8)
+			key := (r8>>4)<<8 | (g8>>4)<<4 | b8>>4
+
+			c := bins[key]
+			if c == nil {
+				c = &bin{}
+				bins[key] = c
+			}
+			c.r += r8
+			c.g += g8
+			c.b += b8
+			c.count++
+		}
+	}
+
+	sorted := make([]*bin, 0, len(bins))
+	for _, c := range bins {
+		sorted = append(sorted, c)
+	}
+	slices.SortFunc(sorted, func(a, b *bin) int {
+		return b.count - a.count
+	})
+
+	colors := make([]Color, 0, n)
+	for _, c := range sorted {
+		if len(colors) == n {
+			break
+		}
+		col := Color{R: uint8(c.r / c.count), G: uint8(c.g / c.count), B: uint8(c.b / c.count)}
+		// Neighbouring cells of one gradient would crowd out other colors
+		if slices.ContainsFunc(colors, func(o Color) bool { return distance(o, col) < 48 }) {
+			continue
+		}
+		colors = append(colors, col)
+	}
+
+	return colors
+}
+
+// distance is the Euclidean RGB distance between two colors.
+func distance(a, b Color) float64 {
+	dr := float64(a.R) - float64(b.R)
+	dg := float64(a.G) - float64(b.G)
+	db := float64(a.B) - float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// Matches reports whether one of the first three colors of p has the given hue name.
+func Matches(p []Color, name string) bool {
+	name = strings.ToLower(name)
+	for _, c := range p[:min(len(p), 3)] {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SortKey orders palettes by the hue of their most dominant chromatic color.
+// Palettes with only neutral colors sort after all chromatic ones, dark to light.
+func SortKey(p []Color) float64 {
+	for _, c := range p {
+		if !c.IsNeutral() {
+			h, _, _ := c.HSV()
+			return h
+		}
+	}
+	if len(p) > 0 {
+		_, _, v := p[0].HSV()
+		return 360 + v
+	}
+	return 400
+}
+
+// ValidName reports whether name is one of Hues.
+func ValidName(name string) bool {
+	return slices.Contains(Hues, strings.ToLower(name))
+}
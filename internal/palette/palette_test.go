@@ -0,0 +1,78 @@
+package palette
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestName verifies hue bucketing of typical colors.
+func TestName(t *testing.T) {
+	tests := []struct {
+		c    Color
+		want string
+	}{
+		{Color{220, 30, 30}, "red"},
+		{Color{240, 140, 20}, "orange"},
+		{Color{30, 160, 40}, "green"},
+		{Color{20, 60, 200}, "blue"},
+		{Color{120, 40, 180}, "purple"},
+		{Color{10, 10, 10}, "black"},
+		{Color{250, 250, 245}, "white"},
+		{Color{128, 128, 128}, "gray"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.c.Name(); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.c, tt.want, got)
+		}
+	}
+}
+
+// TestExtract verifies that the most common colors come first.
+func TestExtract(t *testing.T) {
+	// Arrange: three quarters blue, one quarter orange
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := range 40 {
+		for x := range 40 {
+			c := color.RGBA{20, 60, 200, 255}
+			if x >= 30 {
+				c = color.RGBA{240, 140, 20, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	// Act
+	p := Extract(img, 5)
+
+	// Assert
+	if len(p) != 2 {
+		t.Fatalf("Expected 2 colors, got %v", p)
+	}
+	if p[0].Name() != "blue" || p[1].Name() != "orange" {
+		t.Errorf("Expected blue then orange, got %v", p)
+	}
+	if !Matches(p, "Orange") || Matches(p, "green") {
+		t.Errorf("Unexpected Matches result for %v", p)
+	}
+}
+
+// TestColorJSON verifies colors round-trip as hex strings.
+func TestColorJSON(t *testing.T) {
+	// Act
+	data, err := json.Marshal([]Color{{0x12, 0xab, 0xff}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var back []Color
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	if string(data) != `["#12abff"]` || back[0] != (Color{0x12, 0xab, 0xff}) {
+		t.Errorf("Unexpected round trip: %s → %v", data, back)
+	}
+}
@@ -0,0 +1,89 @@
+// Package phash computes perceptual hashes (dHash) of images and groups
+// near-identical images, regardless of their file names or resolutions.
+package phash
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/nfnt/resize"
+)
+
+// DefaultThreshold is the largest Hamming distance at which two hashes are
+// considered the same picture. It tolerates rescaling and recompression.
+const DefaultThreshold = 6
+
+// DHash returns the 64-bit difference hash of img: the image is reduced to
+// 9×8 grayscale and each bit records whether a pixel is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := range 8 {
+		for x := range 8 {
+			left := luma(small, bounds.Min.X+x, bounds.Min.Y+y)
+			right := luma(small, bounds.Min.X+x+1, bounds.Min.Y+y)
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// luma returns the perceived brightness of a pixel.
+func luma(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
+
+// Distance returns the number of differing bits between two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Group returns groups of paths whose hashes are within threshold of each other,
+// directly or through a chain of similar images. hashes[i] belongs to paths[i].
+// Only groups with at least two members are returned, in order of first appearance.
+func Group(paths []string, hashes []uint64, threshold int) [][]string {
+	// Union-find over all pairs
+	parent := make([]int, len(paths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range hashes {
+		for j := i + 1; j < len(hashes); j++ {
+			if Distance(hashes[i], hashes[j]) <= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	var roots []int
+	for i, path := range paths {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], path)
+	}
+
+	var groups [][]string
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
@@ -0,0 +1,58 @@
+package phash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/nfnt/resize"
+)
+
+// gradient returns a test image with a diagonal gradient, optionally mirrored.
+func gradient(w, h int, mirrored bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := uint8((x*255/w + y*255/h) / 2)
+			if mirrored {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+// TestDHashSurvivesRescaling verifies that resized copies hash alike and different images do not.
+func TestDHashSurvivesRescaling(t *testing.T) {
+	// Arrange
+	original := gradient(640, 360, false)
+	smaller := resize.Resize(320, 180, original, resize.Lanczos3)
+	different := gradient(640, 360, true)
+
+	// Act
+	a, b, c := DHash(original), DHash(smaller), DHash(different)
+
+	// Assert
+	if d := Distance(a, b); d > DefaultThreshold {
+		t.Errorf("Expected rescaled copy within threshold, distance %d", d)
+	}
+	if d := Distance(a, c); d <= DefaultThreshold {
+		t.Errorf("Expected different image beyond threshold, distance %d", d)
+	}
+}
+
+// TestGroup verifies transitive grouping and that singletons are dropped.
+func TestGroup(t *testing.T) {
+	// Arrange: a~b (1 bit), b~c (2 bits), d far away
+	paths := []string{"a", "b", "c", "d"}
+	hashes := []uint64{0b0000, 0b0001, 0b0111, ^uint64(0)}
+
+	// Act
+	groups := Group(paths, hashes, 2)
+
+	// Assert
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("Expected one group of 3, got %v", groups)
+	}
+}
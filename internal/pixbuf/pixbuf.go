@@ -0,0 +1,46 @@
+// Package pixbuf decodes images with gdk-pixbuf. Unlike the rest of GDK it
+// needs neither an initialised GTK nor a display, so headless commands and the
+// daemon can use it too, and it is safe off the main thread.
+package pixbuf
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/gotk3/gotk3/gdk"
+)
+
+// DecodeScaled decodes the image file at path with gdk-pixbuf, fitted into
+// width by height. Its JPEG loader decodes at reduced resolution (DCT scaling),
+// so large photos never occupy memory at full size. It is a cache.ScaledDecoder.
+func DecodeScaled(path string, width, height int) (image.Image, error) {
+	pixbuf, err := gdk.PixbufNewFromFileAtScale(path, width, height, true)
+	if err != nil {
+		return nil, err
+	}
+	return Image(pixbuf)
+}
+
+// Image copies the pixels of an 8-bit RGB or RGBA pixbuf into an image.
+func Image(pixbuf *gdk.Pixbuf) (image.Image, error) {
+	channels := pixbuf.GetNChannels()
+	if pixbuf.GetBitsPerSample() != 8 || (channels != 3 && channels != 4) {
+		return nil, fmt.Errorf("unsupported pixbuf layout: %d channels of %d bits", channels, pixbuf.GetBitsPerSample())
+	}
+
+	w, h, stride := pixbuf.GetWidth(), pixbuf.GetHeight(), pixbuf.GetRowstride()
+	pixels := pixbuf.GetPixels()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		row := pixels[y*stride:]
+		out := img.Pix[y*img.Stride:]
+		for x := range w {
+			copy(out[4*x:4*x+3], row[channels*x:channels*x+3])
+			out[4*x+3] = 255
+			if channels == 4 {
+				out[4*x+3] = row[channels*x+3]
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,93 @@
+// Package prewarm generates the thumbnails, colors and index entries of
+// wallpapers ahead of time, so the GUI shows them without waiting.
+package prewarm
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"waller/internal/cache"
+	"waller/internal/index"
+)
+
+// saveEvery is how many wallpapers are processed between saves of the
+// metadata and the index, so an interrupted build keeps most of its work.
+const saveEvery = 500
+
+// Builder generates everything the GUI needs for a wallpaper.
+type Builder struct {
+	// Index receives the image metadata of each wallpaper; nil skips it.
+	Index *index.Index
+	// Widths are the thumbnail widths generated for each wallpaper.
+	Widths []int
+	// Done, if set, is called after each wallpaper with whether all of it succeeded.
+	Done func(ok bool)
+}
+
+// Run processes files on jobs workers until all are done or ctx is cancelled.
+// The thumbnail metadata and the index are saved along the way and at the end.
+func (b *Builder) Run(ctx context.Context, files []string, jobs int) {
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				ok := b.build(path)
+				if b.Done != nil {
+					b.Done(ok)
+				}
+			}
+		}()
+	}
+
+	for i, path := range files {
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if (i+1)%saveEvery == 0 {
+			b.save()
+		}
+	}
+	close(paths)
+	wg.Wait()
+	b.save()
+}
+
+// build indexes the wallpaper at path and generates its thumbnails and colors.
+// It reports whether all of them succeeded.
+func (b *Builder) build(path string) bool {
+	if b.Index != nil {
+		b.Index.Refresh(path)
+	}
+	ok := true
+	for _, width := range b.Widths {
+		if _, err := cache.GetThumbnail(path, width, false); err != nil {
+			slog.Debug("Could not generate thumbnail", "path", path, "error", err)
+			ok = false
+		}
+	}
+	// The thumbnails yield the colors too, so this rarely decodes anything
+	if _, err := cache.Analyze(path); err != nil {
+		ok = false
+	}
+	return ok
+}
+
+// save writes the thumbnail metadata and the index.
+func (b *Builder) save() {
+	if err := cache.SaveMeta(); err != nil {
+		slog.Warn("Failed to save thumbnail metadata", "error", err)
+	}
+	if b.Index != nil {
+		if err := b.Index.Save(); err != nil {
+			slog.Warn("Failed to save metadata index", "error", err)
+		}
+	}
+}
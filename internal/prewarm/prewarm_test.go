@@ -0,0 +1,87 @@
+package prewarm
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"waller/internal/cache"
+	"waller/internal/index"
+)
+
+// writeLibrary creates a library of n small PNG wallpapers and returns their paths.
+func writeLibrary(t *testing.T, n int) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, n)
+	for i := range paths {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+		for y := range 20 {
+			for x := range 40 {
+				img.Set(x, y, color.RGBA{uint8(60 * i), 0, 0, 255})
+			}
+		}
+		paths[i] = filepath.Join(dir, string(rune('a'+i))+".png")
+		f, err := os.Create(paths[i])
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		err = png.Encode(f, img)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+	return paths
+}
+
+// TestRun verifies that a build generates the thumbnails and colors of every
+// wallpaper, reports a file that cannot be read and saves the index.
+func TestRun(t *testing.T) {
+	// Arrange
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	files := writeLibrary(t, 3)
+	broken := filepath.Join(filepath.Dir(files[0]), "broken.png")
+	os.WriteFile(broken, []byte("not an image"), 0644)
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	idx, err := index.OpenFile(indexPath)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	var done, failed atomic.Int64
+	b := Builder{Index: idx, Widths: []int{cache.DefaultWidth, 2 * cache.DefaultWidth}, Done: func(ok bool) {
+		done.Add(1)
+		if !ok {
+			failed.Add(1)
+		}
+	}}
+
+	// Act
+	b.Run(context.Background(), append(files, broken), 2)
+	saved, openErr := index.OpenFile(indexPath)
+
+	// Assert
+	if done.Load() != 4 || failed.Load() != 1 {
+		t.Errorf("Expected 4 wallpapers with 1 failure, got %d with %d", done.Load(), failed.Load())
+	}
+	for _, path := range files {
+		for _, width := range b.Widths {
+			if _, err := cache.GetThumbnail(path, width, true); err != nil {
+				t.Errorf("Expected a %dpx thumbnail of %s, got %v", width, path, err)
+			}
+		}
+	}
+	if openErr != nil {
+		t.Fatalf("Expected the index to reopen, got %v", openErr)
+	}
+	for _, path := range files {
+		if _, ok := saved.Get(path); !ok {
+			t.Errorf("Expected %s in the saved index", path)
+		}
+	}
+}
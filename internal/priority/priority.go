@@ -0,0 +1,61 @@
+// Package priority lowers the CPU and disk priority of the running process, so
+// background work such as building thumbnails does not slow down the desktop.
+// On Linux both priorities belong to threads, so every thread of the process
+// is changed; threads started later inherit the priority of their creator.
+package priority
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// I/O scheduling classes and fields of ioprio_set(2).
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassIdle  = 3
+)
+
+// Nice sets the niceness of the process to n, from 0 (the default) to 19
+// (lowest priority). Unprivileged processes can only raise it.
+func Nice(n int) error {
+	if n < 0 || n > 19 {
+		return fmt.Errorf("niceness %d out of range 0-19", n)
+	}
+	return eachThread(func(tid int) error {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, tid, n)
+	})
+}
+
+// IdleIO puts the process in the idle I/O scheduling class, so it only reads
+// and writes while no other process uses the disk.
+func IdleIO() error {
+	return eachThread(func(tid int) error {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// eachThread calls set with the ID of every thread of the process.
+func eachThread(set func(tid int) error) error {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// A thread may have exited since the directory was read
+		if err := set(tid); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
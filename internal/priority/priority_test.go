@@ -0,0 +1,87 @@
+package priority
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// childEnv names the test a child process runs; see inChild.
+const childEnv = "WALLER_PRIORITY_TEST"
+
+// inChild reports whether the calling test runs in a child process. If not, it
+// runs the test in one, so lowering the priority does not slow down the other
+// tests, and passes on its result.
+func inChild(t *testing.T) bool {
+	t.Helper()
+	if os.Getenv(childEnv) == t.Name() {
+		return true
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), childEnv+"="+t.Name())
+	out, err := cmd.CombinedOutput()
+	t.Logf("Child process output:\n%s", out)
+	if err != nil {
+		t.Fatalf("Expected the child process to pass, got %v", err)
+	}
+	if bytes.Contains(out, []byte("--- SKIP")) {
+		t.Skip("Skipped in the child process")
+	}
+	return false
+}
+
+// TestNice verifies that every thread gets the niceness.
+func TestNice(t *testing.T) {
+	if !inChild(t) {
+		return
+	}
+
+	// Act
+	err := Nice(10)
+
+	// Assert
+	if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) {
+		t.Skipf("Niceness cannot be changed here: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	eachThread(func(tid int) error {
+		// The raw system call returns 20 - niceness
+		if p, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid); err == nil && 20-p != 10 {
+			t.Errorf("Expected thread %d at niceness 10, got %d", tid, 20-p)
+		}
+		return nil
+	})
+	if err := Nice(20); err == nil {
+		t.Errorf("Expected an out-of-range niceness to be rejected")
+	}
+}
+
+// TestIdleIO verifies that every thread is put in the idle I/O class.
+func TestIdleIO(t *testing.T) {
+	if !inChild(t) {
+		return
+	}
+
+	// Act
+	err := IdleIO()
+
+	// Assert
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSYS) {
+		t.Skipf("I/O priority cannot be changed here: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	eachThread(func(tid int) error {
+		prio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+		if errno == 0 && prio>>ioprioClassShift != ioprioClassIdle {
+			t.Errorf("Expected thread %d in the idle class, got priority %#x", tid, prio)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,163 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"waller/internal/remote"
+)
+
+// maxResponseSize bounds API responses, which only hold metadata.
+const maxResponseSize = 8 << 20
+
+// JSONProvider talks to any gallery with a JSON API, using the URL templates
+// and field mapping from its Config.
+type JSONProvider struct {
+	cfg     Config
+	client  *http.Client
+	fetcher *remote.Fetcher
+}
+
+// NewJSON creates a JSON-API provider. Images are downloaded with fetcher,
+// or into the default download cache if fetcher is nil.
+func NewJSON(cfg Config, fetcher *remote.Fetcher) (*JSONProvider, error) {
+	switch {
+	case cfg.Name == "":
+		return nil, errors.New("provider without a name")
+	case cfg.ListURL == "":
+		return nil, fmt.Errorf("provider %q: list_url is required", cfg.Name)
+	case cfg.Fields.URL == "":
+		return nil, fmt.Errorf("provider %q: fields.url is required", cfg.Name)
+	}
+	return &JSONProvider{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: remote.DefaultTimeout},
+		fetcher: fetcher,
+	}, nil
+}
+
+// Name returns the configured name.
+func (p *JSONProvider) Name() string {
+	return p.cfg.Name
+}
+
+// List requests a page of ListURL.
+func (p *JSONProvider) List(page int) ([]Image, error) {
+	return p.query(p.cfg.ListURL, "", page)
+}
+
+// Search requests a page of SearchURL, or of ListURL if no search URL is configured.
+func (p *JSONProvider) Search(query string, page int) ([]Image, error) {
+	if p.cfg.SearchURL == "" {
+		return p.query(p.cfg.ListURL, query, page)
+	}
+	return p.query(p.cfg.SearchURL, query, page)
+}
+
+// Fetch downloads the full-size image.
+func (p *JSONProvider) Fetch(img Image) (string, error) {
+	if p.fetcher != nil {
+		return p.fetcher.Fetch(img.URL)
+	}
+	return remote.Fetch(img.URL)
+}
+
+// query fills in the URL template, requests it and maps the results.
+func (p *JSONProvider) query(template, query string, page int) ([]Image, error) {
+	rawURL := strings.NewReplacer(
+		"{query}", url.QueryEscape(query),
+		"{page}", strconv.Itoa(page),
+	).Replace(template)
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range p.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", p.cfg.Name, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %w", p.cfg.Name, err)
+	}
+
+	results, ok := lookup(doc, p.cfg.Results).([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: no result list at %q", p.cfg.Name, p.cfg.Results)
+	}
+
+	images := make([]Image, 0, len(results))
+	for _, result := range results {
+		img := Image{
+			ID:       text(lookup(result, p.cfg.Fields.ID)),
+			Title:    text(lookup(result, p.cfg.Fields.Title)),
+			URL:      text(lookup(result, p.cfg.Fields.URL)),
+			ThumbURL: text(lookup(result, p.cfg.Fields.Thumb)),
+		}
+		img.Width, _ = strconv.Atoi(text(lookup(result, p.cfg.Fields.Width)))
+		img.Height, _ = strconv.Atoi(text(lookup(result, p.cfg.Fields.Height)))
+		if !remote.IsURL(img.URL) {
+			continue // Results without a downloadable image are useless
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// lookup follows a dotted path through decoded JSON; numeric elements index arrays.
+// An empty path returns v itself, a missing element nil.
+func lookup(v any, path string) any {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// text formats a JSON scalar as a string; other values yield "".
+func text(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
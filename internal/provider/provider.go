@@ -0,0 +1,152 @@
+// Package provider fetches wallpapers from online galleries.
+// A Provider lists and searches a gallery and downloads its images; downloads
+// go through the remote package's cache and can then be copied into a library.
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image is one wallpaper offered by a provider.
+type Image struct {
+	ID       string
+	Title    string
+	URL      string // Full-size image
+	ThumbURL string // Preview; empty if the gallery has none
+	Width    int
+	Height   int
+}
+
+// Provider is an online wallpaper gallery.
+type Provider interface {
+	Name() string
+	// List returns a page of the gallery's default listing. Pages start at 1.
+	List(page int) ([]Image, error)
+	// Search returns a page of results for query. Pages start at 1.
+	Search(query string, page int) ([]Image, error)
+	// Fetch downloads the full-size image and returns its local path.
+	Fetch(img Image) (string, error)
+}
+
+// Config describes a provider in the config file.
+type Config struct {
+	Name string `json:"name"`
+	// Type selects the implementation; "json" (the default) is the only one so far.
+	Type string `json:"type,omitempty"`
+	// ListURL and SearchURL are request URLs in which {page} and {query} are replaced.
+	ListURL   string `json:"list_url"`
+	SearchURL string `json:"search_url,omitempty"`
+	// Headers are sent with every API request, e.g. for an API key.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Results is the dotted path to the result array in the response ("" = the response itself).
+	Results string `json:"results,omitempty"`
+	Fields  Fields `json:"fields"`
+}
+
+// Fields maps image attributes to dotted paths within one result, e.g. "urls.full".
+type Fields struct {
+	ID     string `json:"id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	URL    string `json:"url"`
+	Thumb  string `json:"thumb,omitempty"`
+	Width  string `json:"width,omitempty"`
+	Height string `json:"height,omitempty"`
+}
+
+// New creates the provider described by cfg.
+func New(cfg Config) (Provider, error) {
+	switch cfg.Type {
+	case "", "json":
+		return NewJSON(cfg, nil)
+	}
+	return nil, fmt.Errorf("provider %q: unknown type %q", cfg.Name, cfg.Type)
+}
+
+// Decode reads the provider entries of the config, skipping malformed ones.
+func Decode(raw []json.RawMessage) []Config {
+	var cfgs []Config
+	for _, r := range raw {
+		var cfg Config
+		if err := json.Unmarshal(r, &cfg); err != nil {
+			slog.Warn("Skipping wallpaper provider", "error", err)
+			continue
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs
+}
+
+// FromConfig creates the providers listed in the config, skipping invalid entries.
+func FromConfig(cfgs []Config) []Provider {
+	var providers []Provider
+	for _, cfg := range cfgs {
+		p, err := New(cfg)
+		if err != nil {
+			slog.Warn("Skipping wallpaper provider", "error", err)
+			continue
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// AddToLibrary downloads img and copies it into the library directory dir.
+// The file is named after the provider and image ID, so adding the same image
+// twice returns the existing copy.
+func AddToLibrary(p Provider, img Image, dir string) (string, error) {
+	src, err := p.Fetch(img)
+	if err != nil {
+		return "", err
+	}
+
+	id := img.ID
+	if id == "" {
+		id = strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))
+	}
+	dest := filepath.Join(dir, sanitize(p.Name()+"-"+id)+filepath.Ext(src))
+	if _, err := os.Stat(dest); err == nil {
+		return dest, nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	// The library is watched, so the file only appears under its name once complete
+	tmp, err := os.CreateTemp(dir, ".waller-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	return dest, os.Rename(tmp.Name(), dest)
+}
+
+// sanitize replaces characters that are awkward in file names.
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
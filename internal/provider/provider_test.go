@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"waller/internal/remote"
+)
+
+// newGallery starts a mock gallery API serving two images and their files.
+func newGallery(t *testing.T) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/list", "/api/search":
+			if r.Header.Get("X-Api-Key") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			title := "page " + r.URL.Query().Get("page")
+			if q := r.URL.Query().Get("q"); q != "" {
+				title = "search " + q
+			}
+			w.Write([]byte(`{"meta": {"total": 3}, "data": [
+				{"id": 7, "title": "` + title + `", "size": {"w": 3840, "h": 2160},
+				 "urls": {"full": "` + server.URL + `/img/7.png", "thumb": "` + server.URL + `/thumb/7.jpg"}},
+				{"id": "b/8", "title": "No thumbnail", "urls": {"full": "` + server.URL + `/img/8.jpg"}},
+				{"id": 9, "title": "Missing image", "urls": {}}
+			]}`))
+		case "/img/7.png":
+			w.Write([]byte("image seven"))
+		case "/img/8.jpg":
+			w.Write([]byte("image eight"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testConfig maps the mock gallery's fields.
+func testConfig(server *httptest.Server) Config {
+	return Config{
+		Name:      "mock",
+		ListURL:   server.URL + "/api/list?page={page}",
+		SearchURL: server.URL + "/api/search?q={query}&page={page}",
+		Headers:   map[string]string{"X-Api-Key": "secret"},
+		Results:   "data",
+		Fields: Fields{
+			ID:     "id",
+			Title:  "title",
+			URL:    "urls.full",
+			Thumb:  "urls.thumb",
+			Width:  "size.w",
+			Height: "size.h",
+		},
+	}
+}
+
+// TestJSONProviderList verifies field mapping of a listing.
+func TestJSONProviderList(t *testing.T) {
+	// Arrange
+	server := newGallery(t)
+	p, err := NewJSON(testConfig(server), nil)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	images, err := p.List(2)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 images with a URL, got %d", len(images))
+	}
+	want := Image{
+		ID:       "7",
+		Title:    "page 2",
+		URL:      server.URL + "/img/7.png",
+		ThumbURL: server.URL + "/thumb/7.jpg",
+		Width:    3840,
+		Height:   2160,
+	}
+	if images[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, images[0])
+	}
+	if images[1].ID != "b/8" || images[1].ThumbURL != "" {
+		t.Errorf("Unexpected second image %+v", images[1])
+	}
+}
+
+// TestJSONProviderSearch verifies the query is escaped into the search URL.
+func TestJSONProviderSearch(t *testing.T) {
+	// Arrange
+	server := newGallery(t)
+	p, err := NewJSON(testConfig(server), nil)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+
+	// Act
+	images, err := p.Search("misty forest&more", 1)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(images) == 0 || images[0].Title != "search misty forest&more" {
+		t.Errorf("Expected the query to reach the server intact, got %+v", images)
+	}
+}
+
+// TestJSONProviderErrors verifies invalid configs and failed requests are reported.
+func TestJSONProviderErrors(t *testing.T) {
+	// Arrange
+	server := newGallery(t)
+	noKey := testConfig(server)
+	noKey.Headers = nil
+	wrongPath := testConfig(server)
+	wrongPath.Results = "items"
+
+	// Act
+	_, missingURL := New(Config{Name: "x", ListURL: server.URL})
+	_, unknownType := New(Config{Name: "x", Type: "ftp"})
+	unauthorized, _ := NewJSON(noKey, nil)
+	_, statusErr := unauthorized.List(1)
+	misconfigured, _ := NewJSON(wrongPath, nil)
+	_, pathErr := misconfigured.List(1)
+
+	// Assert
+	for name, err := range map[string]error{"missing url field": missingURL, "unknown type": unknownType, "unauthorized": statusErr, "wrong results path": pathErr} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if got := FromConfig([]Config{testConfig(server), {Name: "broken"}}); len(got) != 1 {
+		t.Errorf("Expected only the valid provider, got %d", len(got))
+	}
+}
+
+// TestAddToLibrary verifies downloads are copied into the library once.
+func TestAddToLibrary(t *testing.T) {
+	// Arrange
+	server := newGallery(t)
+	p, err := NewJSON(testConfig(server), remote.New(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	images, err := p.List(1)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	library := t.TempDir()
+
+	// Act
+	first, err := AddToLibrary(p, images[0], library)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	again, _ := AddToLibrary(p, images[0], library)
+	second, err := AddToLibrary(p, images[1], library)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	if first != filepath.Join(library, "mock-7.png") || again != first {
+		t.Errorf("Expected mock-7.png both times, got %s and %s", first, again)
+	}
+	if second != filepath.Join(library, "mock-b_8.jpg") {
+		t.Errorf("Expected a sanitized name, got %s", second)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "image seven" {
+		t.Errorf("Unexpected content %q", data)
+	}
+	if entries, _ := os.ReadDir(library); len(entries) != 2 {
+		t.Errorf("Expected 2 files and no leftovers, found %d", len(entries))
+	}
+}
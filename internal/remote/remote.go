@@ -0,0 +1,259 @@
+// Package remote downloads wallpapers given by URL into the waller cache directory.
+// Each URL is downloaded once and revalidated with ETag and Last-Modified on
+// later use; when the server cannot be reached the cached copy is used as is.
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults used by Fetch.
+const (
+	DefaultMaxSize = 64 << 20 // 64 MiB, enough for 8K PNGs
+	DefaultTimeout = 30 * time.Second
+)
+
+// ErrTooLarge is returned when a download exceeds the size limit.
+var ErrTooLarge = errors.New("download exceeds the maximum size")
+
+// extensions maps image content types to file extensions, for URLs whose
+// path does not end in one. Formats are recognized by extension elsewhere.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tiff",
+	"image/avif": ".avif",
+	"image/jxl":  ".jxl",
+}
+
+// record is stored next to each download to revalidate it later.
+type record struct {
+	URL          string `json:"url"`
+	File         string `json:"file"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Fetcher downloads URLs into Dir.
+type Fetcher struct {
+	Dir     string
+	Client  *http.Client
+	MaxSize int64
+
+	locksMu sync.Mutex
+	locks   map[string]*sync.Mutex
+}
+
+// DefaultDir returns the download cache in the user's cache directory.
+func DefaultDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "waller", "downloads"), nil
+}
+
+// New returns a Fetcher storing downloads in dir with the default limits.
+func New(dir string) *Fetcher {
+	return &Fetcher{
+		Dir:     dir,
+		Client:  &http.Client{Timeout: DefaultTimeout},
+		MaxSize: DefaultMaxSize,
+	}
+}
+
+var (
+	defaultFetcher     *Fetcher
+	defaultFetcherOnce sync.Once
+	defaultFetcherErr  error
+)
+
+// Fetch downloads rawURL into the default download cache and returns the local path.
+func Fetch(rawURL string) (string, error) {
+	defaultFetcherOnce.Do(func() {
+		dir, err := DefaultDir()
+		if err != nil {
+			defaultFetcherErr = err
+			return
+		}
+		defaultFetcher = New(dir)
+	})
+	if defaultFetcherErr != nil {
+		return "", defaultFetcherErr
+	}
+	return defaultFetcher.Fetch(rawURL)
+}
+
+// IsURL reports whether s is an http or https URL rather than a file path.
+func IsURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// Fetch returns the local copy of rawURL, downloading it if it is not cached
+// or has changed on the server.
+func (f *Fetcher) Fetch(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	sum := sha256.Sum256([]byte(rawURL))
+	key := hex.EncodeToString(sum[:16])
+	recordPath := filepath.Join(f.Dir, key+".json")
+
+	mu := f.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return "", err
+	}
+
+	// A cached copy is only usable if both the record and the file survived
+	var cached *record
+	if data, err := os.ReadFile(recordPath); err == nil {
+		var r record
+		if json.Unmarshal(data, &r) == nil && r.URL == rawURL {
+			if _, err := os.Stat(filepath.Join(f.Dir, r.File)); err == nil {
+				cached = &r
+			}
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		if cached != nil {
+			slog.Warn("Download failed, using cached copy", "url", rawURL, "error", err)
+			return filepath.Join(f.Dir, cached.File), nil
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return filepath.Join(f.Dir, cached.File), nil
+	case resp.StatusCode >= 500 && cached != nil:
+		slog.Warn("Server error, using cached copy", "url", rawURL, "status", resp.Status)
+		return filepath.Join(f.Dir, cached.File), nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("download %s: %s", rawURL, resp.Status)
+	}
+
+	if f.MaxSize > 0 && resp.ContentLength > f.MaxSize {
+		return "", fmt.Errorf("download %s: %w (%d bytes)", rawURL, ErrTooLarge, resp.ContentLength)
+	}
+
+	r := record{
+		URL:          rawURL,
+		File:         key + extension(u, resp.Header.Get("Content-Type")),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if err := f.save(resp.Body, r.File); err != nil {
+		return "", fmt.Errorf("download %s: %w", rawURL, err)
+	}
+
+	// The file may have changed type since the last download
+	if cached != nil && cached.File != r.File {
+		os.Remove(filepath.Join(f.Dir, cached.File))
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(recordPath, data, 0644); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.Dir, r.File), nil
+}
+
+// save writes body to name in the download directory, replacing any previous
+// copy only once the download completed within the size limit.
+func (f *Fetcher) save(body io.Reader, name string) error {
+	tmp, err := os.CreateTemp(f.Dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	limit := f.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxSize
+	}
+	n, err := io.Copy(tmp, io.LimitReader(body, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return ErrTooLarge
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.Dir, name))
+}
+
+// extension picks the file extension of a download from its URL path,
+// falling back to its content type.
+func extension(u *url.URL, contentType string) string {
+	ext := strings.ToLower(path.Ext(u.Path))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp", ".tif", ".tiff", ".avif", ".jxl":
+		return ext
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	if ext, ok := extensions[strings.TrimSpace(strings.ToLower(mediaType))]; ok {
+		return ext
+	}
+	return ".jpg" // Unknown; image loaders sniff the content anyway
+}
+
+// lock returns the mutex guarding downloads for key.
+func (f *Fetcher) lock(key string) *sync.Mutex {
+	f.locksMu.Lock()
+	defer f.locksMu.Unlock()
+	if f.locks == nil {
+		f.locks = make(map[string]*sync.Mutex)
+	}
+	mu, ok := f.locks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		f.locks[key] = mu
+	}
+	return mu
+}
@@ -0,0 +1,171 @@
+package remote
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestFetchRevalidates verifies a URL is downloaded once and then revalidated with its ETag.
+func TestFetchRevalidates(t *testing.T) {
+	// Arrange
+	var downloads, revalidations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png data"))
+	}))
+	defer server.Close()
+	f := New(t.TempDir())
+
+	// Act
+	first, err := f.Fetch(server.URL + "/wallpaper")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := f.Fetch(server.URL + "/wallpaper")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	if first != second {
+		t.Errorf("Expected the same local path, got %s and %s", first, second)
+	}
+	if !strings.HasSuffix(first, ".png") {
+		t.Errorf("Expected the extension from the content type, got %s", first)
+	}
+	if data, _ := os.ReadFile(first); string(data) != "png data" {
+		t.Errorf("Unexpected content %q", data)
+	}
+	if downloads.Load() != 1 || revalidations.Load() != 1 {
+		t.Errorf("Expected 1 download and 1 revalidation, got %d and %d", downloads.Load(), revalidations.Load())
+	}
+}
+
+// TestFetchLastModified verifies revalidation with If-Modified-Since and that
+// changed content replaces the cached copy.
+func TestFetchLastModified(t *testing.T) {
+	// Arrange
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	content := "old"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+	f := New(t.TempDir())
+	url := server.URL + "/a.jpg"
+
+	// Act
+	path, err := f.Fetch(url)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	unchanged, _ := os.ReadFile(path)
+	modified = modified.Add(time.Hour)
+	content = "new"
+	if path, err = f.Fetch(url); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	changed, _ := os.ReadFile(path)
+
+	// Assert
+	if string(unchanged) != "old" || string(changed) != "new" {
+		t.Errorf("Expected old then new content, got %q and %q", unchanged, changed)
+	}
+}
+
+// TestFetchMaxSize verifies oversized downloads are rejected and not cached.
+func TestFetchMaxSize(t *testing.T) {
+	// Arrange: one response announces its size, the other streams without a length
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked.jpg" {
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	f := New(dir)
+	f.MaxSize = 50
+
+	for _, name := range []string{"/sized.jpg", "/chunked.jpg"} {
+		// Act
+		_, err := f.Fetch(server.URL + name)
+
+		// Assert
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: expected ErrTooLarge, got %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected nothing cached, found %d files", len(entries))
+	}
+}
+
+// TestFetchOffline verifies the cached copy is used when the server is unreachable.
+func TestFetchOffline(t *testing.T) {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("cached"))
+	}))
+	f := New(t.TempDir())
+	url := server.URL + "/a.webp"
+	cached, err := f.Fetch(url)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	server.Close()
+
+	// Act
+	path, err := f.Fetch(url)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Expected the cached copy, got %v", err)
+	}
+	if path != cached || filepath.Ext(path) != ".webp" {
+		t.Errorf("Expected %s, got %s", cached, path)
+	}
+	if _, err := f.Fetch(server.URL + "/never-downloaded.jpg"); err == nil {
+		t.Errorf("Expected an error for an uncached URL while offline")
+	}
+}
+
+// TestFetchTimeout verifies a stalled server does not block forever.
+func TestFetchTimeout(t *testing.T) {
+	// Arrange
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	f := New(t.TempDir())
+	f.Client.Timeout = 50 * time.Millisecond
+
+	// Act
+	_, err := f.Fetch(server.URL + "/slow.jpg")
+
+	// Assert
+	if err == nil {
+		t.Errorf("Expected a timeout error")
+	}
+}
@@ -0,0 +1,203 @@
+// Package render prepares wallpapers at the exact resolution of a monitor.
+// Each image is decoded at the size it is shown at, fitted and resampled once
+// per monitor size and fit mode, and the rendition is kept in the waller cache
+// directory, so the daemon never has to decode and scale the original again.
+package render
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"waller/internal/cache"
+	"waller/internal/fit"
+
+	"github.com/nfnt/resize"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxRenditions is how many renditions are kept; the least recently shown are removed.
+const MaxRenditions = 32
+
+// dir is resolved once at first use, like the thumbnail directory.
+var (
+	dir     string
+	dirOnce sync.Once
+	dirErr  error
+)
+
+// locks serializes renders of the same rendition, so monitors of equal size
+// share one render.
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*sync.Mutex)
+)
+
+func initDir() {
+	dirOnce.Do(func() {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			dirErr = err
+			return
+		}
+		dir = filepath.Join(cacheDir, "waller", "rendered")
+		dirErr = os.MkdirAll(dir, 0755)
+	})
+}
+
+// Render returns the path to a rendition of the image at path fitted to mon in
+// mode m, rendering it if it is not cached yet. Images already at the monitor's
+// size are returned as they are.
+func Render(path string, mon fit.Monitor, m fit.Mode) (string, error) {
+	if mon.Width <= 0 || mon.Height <= 0 {
+		return "", fmt.Errorf("invalid monitor size %dx%d", mon.Width, mon.Height)
+	}
+	initDir()
+	if dirErr != nil {
+		return "", dirErr
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	// Size and mtime are part of the key so an edited source is rendered again
+	sum := md5.Sum([]byte(strings.Join([]string{
+		path,
+		strconv.FormatInt(info.Size(), 10),
+		strconv.FormatInt(info.ModTime().UnixNano(), 10),
+		strconv.Itoa(mon.Width), strconv.Itoa(mon.Height), string(m),
+	}, "\x00")))
+	key := hex.EncodeToString(sum[:])
+	out := filepath.Join(dir, key+".jpg")
+
+	mu := lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := os.Stat(out); err == nil {
+		// The mtime records the last use for pruning
+		now := time.Now()
+		os.Chtimes(out, now, now)
+		return out, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width == mon.Width && cfg.Height == mon.Height {
+		return path, nil
+	}
+
+	// Only as many pixels as the rendition needs are decoded, where possible
+	w, h := fit.Scaled(cfg.Width, cfg.Height, mon, m)
+	img, err := cache.DecodeScaled(path, w, h)
+	if err != nil {
+		return "", err
+	}
+
+	rendered := Fit(img, mon, m)
+
+	tmp, err := os.CreateTemp(dir, key+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	err = jpeg.Encode(tmp, rendered, &jpeg.Options{Quality: 95})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return "", err
+	}
+
+	prune(MaxRenditions)
+	return out, nil
+}
+
+// Fit returns img fitted to mon in mode m as an image of exactly the monitor's
+// size. Scaling uses a Lanczos filter; uncovered areas are black.
+func Fit(img image.Image, mon fit.Monitor, m fit.Mode) image.Image {
+	b := img.Bounds()
+	w, h := fit.Scaled(b.Dx(), b.Dy(), mon, m)
+	scaled := img
+	if w != b.Dx() || h != b.Dy() {
+		scaled = resize.Resize(uint(w), uint(h), img, resize.Lanczos3)
+	}
+
+	// Center the scaled image, cropping or leaving bars as the mode requires
+	out := image.NewRGBA(image.Rect(0, 0, mon.Width, mon.Height))
+	draw.Draw(out, out.Bounds(), image.Black, image.Point{}, draw.Src)
+	offset := image.Pt((mon.Width-w)/2, (mon.Height-h)/2)
+	sb := scaled.Bounds()
+	draw.Draw(out, image.Rectangle{Min: offset, Max: offset.Add(sb.Size())}, scaled, sb.Min, draw.Over)
+	return out
+}
+
+// prune removes all but the keep most recently used renditions.
+func prune(keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type rendition struct {
+		path string
+		used time.Time
+	}
+	var all []rendition
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".jpg" {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			all = append(all, rendition{filepath.Join(dir, entry.Name()), info.ModTime()})
+		}
+	}
+	if len(all) <= keep {
+		return
+	}
+
+	slices.SortFunc(all, func(a, b rendition) int {
+		return b.used.Compare(a.used)
+	})
+	for _, r := range all[keep:] {
+		os.Remove(r.path)
+	}
+}
+
+// lock returns the mutex guarding the rendition key.
+func lock(key string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	mu, ok := locks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[key] = mu
+	}
+	return mu
+}
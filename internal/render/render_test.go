@@ -0,0 +1,153 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"waller/internal/fit"
+)
+
+// useTempCache points the rendition cache at an empty directory for the test.
+func useTempCache(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dirOnce = sync.Once{}
+}
+
+// writePNG writes a w×h image, red on the left half and blue on the right, to path.
+func writePNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// TestFit verifies the output size and placement of each fit mode.
+func TestFit(t *testing.T) {
+	// Arrange: a 4:3 image on a 16:9 monitor
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for i := range img.Pix {
+		img.Pix[i] = 255 // White
+	}
+	mon := fit.Monitor{Width: 160, Height: 90}
+
+	tests := []struct {
+		mode     fit.Mode
+		barWhite bool // Whether the left edge is covered by the image
+	}{
+		{mode: fit.Cover, barWhite: true},
+		{mode: fit.Contain, barWhite: false},
+		{mode: fit.Stretch, barWhite: true},
+	}
+
+	for _, tt := range tests {
+		// Act
+		out := Fit(img, mon, tt.mode)
+
+		// Assert
+		if b := out.Bounds(); b.Dx() != 160 || b.Dy() != 90 {
+			t.Errorf("%s: expected 160x90, got %v", tt.mode, b)
+		}
+		r, _, _, _ := out.At(2, 45).RGBA()
+		if white := r > 0xF000; white != tt.barWhite {
+			t.Errorf("%s: expected left edge covered=%v, got red %x", tt.mode, tt.barWhite, r)
+		}
+	}
+}
+
+// TestRender verifies that renditions are cached and that images at the
+// monitor's size are used as they are.
+func TestRender(t *testing.T) {
+	// Arrange
+	useTempCache(t)
+	src := t.TempDir()
+	large := filepath.Join(src, "large.png")
+	writePNG(t, large, 640, 360)
+	exact := filepath.Join(src, "exact.png")
+	writePNG(t, exact, 320, 180)
+	mon := fit.Monitor{Width: 320, Height: 180}
+
+	// Act
+	first, err := Render(large, mon, fit.Cover)
+	second, secondErr := Render(large, mon, fit.Cover)
+	contained, containErr := Render(large, mon, fit.Contain)
+	same, sameErr := Render(exact, mon, fit.Cover)
+
+	// Assert
+	if err != nil || secondErr != nil || containErr != nil || sameErr != nil {
+		t.Fatalf("Expected no errors, got %v, %v, %v, %v", err, secondErr, containErr, sameErr)
+	}
+	if first != second {
+		t.Errorf("Expected the cached rendition to be reused, got %s and %s", first, second)
+	}
+	if contained == first {
+		t.Errorf("Expected a separate rendition per fit mode")
+	}
+	if same != exact {
+		t.Errorf("Expected an image at monitor size to be used directly, got %s", same)
+	}
+
+	f, err := os.Open(first)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width != 320 || cfg.Height != 180 {
+		t.Errorf("Expected a 320x180 rendition, got %+v, %v", cfg, err)
+	}
+}
+
+// TestPrune verifies that only the most recently used renditions are kept.
+func TestPrune(t *testing.T) {
+	// Arrange: three renditions, the first used most recently
+	useTempCache(t)
+	src := t.TempDir()
+	mon := fit.Monitor{Width: 32, Height: 18}
+	var rendered []string
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		path := filepath.Join(src, name)
+		writePNG(t, path, 64, 36)
+		out, err := Render(path, mon, fit.Cover)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		rendered = append(rendered, out)
+	}
+	for i, age := range []time.Duration{0, 2 * time.Hour, time.Hour} {
+		used := time.Now().Add(-age)
+		os.Chtimes(rendered[i], used, used)
+	}
+
+	// Act
+	prune(2)
+
+	// Assert
+	for i, want := range []bool{true, false, true} {
+		_, err := os.Stat(rendered[i])
+		if kept := err == nil; kept != want {
+			t.Errorf("Rendition %d: expected kept=%v, got %v", i, want, kept)
+		}
+	}
+}
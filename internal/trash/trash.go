@@ -0,0 +1,78 @@
+// Package trash moves files to the user's trash following the freedesktop.org
+// Trash specification, so removed wallpapers can be restored from a file manager.
+package trash
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Dir returns the home trash directory ($XDG_DATA_HOME/Trash).
+func Dir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
+
+// Trash moves the file at path into the home trash.
+// Files on another filesystem than the trash cannot be moved and return an error.
+func Trash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return err
+	}
+	filesDir := filepath.Join(dir, "files")
+	infoDir := filepath.Join(dir, "info")
+	if err := os.MkdirAll(filesDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infoDir, 0700); err != nil {
+		return err
+	}
+
+	// Reserve a unique name by creating its .trashinfo exclusively
+	base := filepath.Base(abs)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	name := base
+	var info *os.File
+	for n := 2; ; n++ {
+		info, err = os.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		name = fmt.Sprintf("%s.%d%s", stem, n, ext)
+	}
+
+	_, err = fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: abs}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+	info.Close()
+	if err != nil {
+		os.Remove(info.Name())
+		return err
+	}
+
+	if err := os.Rename(abs, filepath.Join(filesDir, name)); err != nil {
+		os.Remove(info.Name())
+		return err
+	}
+	return nil
+}
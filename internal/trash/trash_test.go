@@ -0,0 +1,45 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTrash verifies files are moved with trash info and name clashes are resolved.
+func TestTrash(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, "data"))
+
+	first := filepath.Join(tmpDir, "a", "copy.jpg")
+	second := filepath.Join(tmpDir, "b", "copy.jpg")
+	for _, p := range []string{first, second} {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte("fake content"), 0644)
+	}
+
+	// Act
+	if err := Trash(first); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := Trash(second); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Assert
+	dir, _ := Dir()
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be gone", first)
+	}
+	for _, name := range []string{"copy.jpg", "copy.2.jpg"} {
+		if _, err := os.Stat(filepath.Join(dir, "files", name)); err != nil {
+			t.Errorf("Expected %s in trash: %v", name, err)
+		}
+	}
+	info, err := os.ReadFile(filepath.Join(dir, "info", "copy.2.jpg.trashinfo"))
+	if err != nil || !strings.Contains(string(info), "Path="+second) {
+		t.Errorf("Expected trash info pointing at %s, got %q (%v)", second, info, err)
+	}
+}
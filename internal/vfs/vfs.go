@@ -0,0 +1,691 @@
+// Package vfs lets zip and tar archives act as folders of wallpapers.
+// An image inside an archive is addressed by a virtual path made of the archive
+// path, "!/" and the entry name, e.g. "/home/me/packs/nature.zip!/forest/01.jpg".
+//
+// Entries are read in place. Tar archives cannot be read at random, so an
+// entry is reached by reading the archive from the start; the position is kept
+// for the next entry, so reading the entries in order reads the archive once.
+// Either kind of entry can be extracted to a real file, e.g. for the daemon.
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Separator divides the archive path from the entry name in a virtual path.
+const Separator = "!/"
+
+// sourceFile records in a cache directory the path of the archive it belongs
+// to, so Prune can tell when the archive changed or was removed.
+const sourceFile = ".archive"
+
+// abandonedAge is how old a cache directory without a source record must be
+// before Prune removes it; younger ones may be in the middle of being written.
+const abandonedAge = time.Hour
+
+// archiveSuffixes are the recognized archive file name endings.
+var archiveSuffixes = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2"}
+
+var (
+	dir     string
+	dirOnce sync.Once
+	dirErr  error
+)
+
+// locks serializes extraction per archive.
+var (
+	locksMu sync.Mutex
+	locks   = make(map[string]*sync.Mutex)
+)
+
+func initDir() {
+	dirOnce.Do(func() {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			dirErr = err
+			return
+		}
+		dir = filepath.Join(cacheDir, "waller", "archives")
+		dirErr = os.MkdirAll(dir, 0755)
+	})
+}
+
+// IsArchive reports whether path names a supported archive.
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Join returns the virtual path of entry within archive.
+func Join(archive, entry string) string {
+	return archive + Separator + entry
+}
+
+// Split divides a virtual path into the archive path and entry name.
+// ok is false for ordinary paths.
+func Split(p string) (archive, entry string, ok bool) {
+	for i := 0; ; {
+		j := strings.Index(p[i:], Separator)
+		if j < 0 {
+			return "", "", false
+		}
+		i += j
+		if IsArchive(p[:i]) {
+			return p[:i], p[i+len(Separator):], true
+		}
+		i += len(Separator)
+	}
+}
+
+// IsVirtual reports whether p addresses an archive entry.
+func IsVirtual(p string) bool {
+	_, _, ok := Split(p)
+	return ok
+}
+
+// List returns the names of the regular files in archive.
+// Entries whose names would escape a directory when extracted are left out.
+func List(archive string) ([]string, error) {
+	d, err := readDir(archive)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(d.names), nil
+}
+
+// Open opens the file or archive entry at p for reading.
+func Open(p string) (io.ReadCloser, error) {
+	archive, entry, ok := Split(p)
+	if !ok {
+		return os.Open(p)
+	}
+
+	d, err := acquireDir(archive)
+	if err != nil {
+		return nil, err
+	}
+	var rc io.ReadCloser
+	switch _, ok := d.sizes[entry]; {
+	case !ok:
+		err = notFound(p)
+	case d.isZip:
+		rc, err = d.openZip(entry)
+	default:
+		rc, err = d.openTar(entry)
+	}
+	if err != nil {
+		dirsMu.Lock()
+		d.release()
+		dirsMu.Unlock()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// Stat describes the file or archive entry at p. Entries report the
+// modification time of their archive, so replacing a pack invalidates them.
+func Stat(p string) (fs.FileInfo, error) {
+	archive, entry, ok := Split(p)
+	if !ok {
+		return os.Stat(p)
+	}
+
+	d, err := readDir(archive)
+	if err != nil {
+		return nil, err
+	}
+	size, ok := d.sizes[entry]
+	if !ok {
+		return nil, notFound(p)
+	}
+	return entryInfo{name: path.Base(entry), size: size, modTime: d.modTime}, nil
+}
+
+// Extract returns a real file holding the content at p: p itself for ordinary
+// paths, otherwise a copy of the entry in the cache directory.
+func Extract(p string) (string, error) {
+	archive, entry, ok := Split(p)
+	if !ok {
+		return p, nil
+	}
+
+	target, err := cacheDir(archive)
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(target, filepath.FromSlash(entry))
+
+	mu := lock(target)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+	rc, err := Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	if err := claimDir(target, archive); err != nil {
+		return "", err
+	}
+	return out, writeFile(out, rc)
+}
+
+// isZip reports whether archive is a zip file rather than a tar file.
+func isZip(archive string) bool {
+	return strings.HasSuffix(strings.ToLower(archive), ".zip")
+}
+
+// maxOpenArchives is how many archives are kept open for the entries read
+// next when no entry of theirs is being read.
+const maxOpenArchives = 8
+
+// maxTarStreams is how many positions in a tar archive are kept for reading
+// further entries, one per reader working through it at once.
+const maxTarStreams = 4
+
+// archiveDir is the directory of an archive as it was at the given size and
+// modification time.
+type archiveDir struct {
+	archive string
+	size    int64
+	modTime time.Time
+	isZip   bool
+	// names are the regular files with safe names in archive order, and
+	// order gives the position of each among them.
+	names []string
+	sizes map[string]int64
+	order map[string]int
+	// While the archive is open, zr and files read a zip archive and idle
+	// holds the streams of a tar archive that are not reading an entry.
+	zr    *zip.ReadCloser
+	files map[string]*zip.File
+	idle  []*tarStream
+	// refs counts the entries being read, which keep the archive open.
+	// A dropped directory was replaced by that of a newer archive.
+	refs    int
+	dropped bool
+	used    int64
+}
+
+// archiveDirs caches the directories of archives, since Stat is called for every
+// entry and reading a directory means parsing the whole zip directory or
+// reading through the whole tar file. useCount orders their uses.
+var (
+	dirsMu      sync.Mutex
+	archiveDirs = make(map[string]*archiveDir)
+	useCount    int64
+)
+
+// readDir returns the directory of archive, reading it again only after the
+// archive changed.
+func readDir(archive string) (*archiveDir, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	dirsMu.Lock()
+	d, ok := archiveDirs[archive]
+	dirsMu.Unlock()
+	if ok && d.size == info.Size() && d.modTime.Equal(info.ModTime()) {
+		return d, nil
+	}
+
+	d = &archiveDir{archive: archive, size: info.Size(), modTime: info.ModTime(), isZip: isZip(archive),
+		sizes: make(map[string]int64), order: make(map[string]int)}
+	add := func(name string, size int64) {
+		d.order[name] = len(d.names)
+		d.names = append(d.names, name)
+		d.sizes[name] = size
+	}
+	if d.isZip {
+		zr, files, err := openZip(archive)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if files[f.Name] == f {
+				add(f.Name, int64(f.UncompressedSize64))
+			}
+		}
+		d.zr, d.files = zr, files
+	} else {
+		err := walkTar(archive, func(hdr *tar.Header, _ io.Reader) error {
+			add(hdr.Name, hdr.Size)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dirsMu.Lock()
+	defer dirsMu.Unlock()
+	if old, ok := archiveDirs[archive]; ok {
+		old.dropped = true
+		if old.refs == 0 {
+			old.closeFiles()
+		}
+	}
+	useCount++
+	d.used = useCount
+	archiveDirs[archive] = d
+	evict()
+	return d, nil
+}
+
+// openZip opens the zip archive and returns its regular files with safe names.
+func openZip(archive string) (*zip.ReadCloser, map[string]*zip.File, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		if f.Mode().IsRegular() && safeName(f.Name) {
+			files[f.Name] = f
+		}
+	}
+	return zr, files, nil
+}
+
+// acquireDir returns the directory of archive with its archive open, counting
+// an entry read that must be ended with release.
+func acquireDir(archive string) (*archiveDir, error) {
+	for {
+		d, err := readDir(archive)
+		if err != nil {
+			return nil, err
+		}
+		dirsMu.Lock()
+		if d.dropped {
+			dirsMu.Unlock()
+			continue // The archive was replaced meanwhile
+		}
+		d.refs++
+		useCount++
+		d.used = useCount
+		reopen := d.isZip && d.zr == nil
+		dirsMu.Unlock()
+		if !reopen {
+			return d, nil
+		}
+
+		zr, files, err := openZip(archive)
+		dirsMu.Lock()
+		defer dirsMu.Unlock()
+		if err != nil {
+			d.release()
+			return nil, err
+		}
+		if d.zr == nil {
+			d.zr, d.files = zr, files
+			evict()
+		} else {
+			zr.Close() // Another reader reopened it first
+		}
+		return d, nil
+	}
+}
+
+// release ends an entry read of d. The caller must hold dirsMu.
+func (d *archiveDir) release() {
+	d.refs--
+	if d.refs == 0 && d.dropped {
+		d.closeFiles()
+	}
+}
+
+// closeFiles closes the archive of d. The caller must hold dirsMu.
+func (d *archiveDir) closeFiles() {
+	if d.zr != nil {
+		d.zr.Close()
+		d.zr, d.files = nil, nil
+	}
+	for _, s := range d.idle {
+		s.Close()
+	}
+	d.idle = nil
+}
+
+// open reports whether the archive of d is open.
+func (d *archiveDir) open() bool {
+	return d.zr != nil || len(d.idle) > 0
+}
+
+// evict closes the least recently used archives that no entry is read from
+// until at most maxOpenArchives are open. The caller must hold dirsMu.
+func evict() {
+	var idle []*archiveDir
+	open := 0
+	for _, d := range archiveDirs {
+		if d.open() {
+			open++
+			if d.refs == 0 {
+				idle = append(idle, d)
+			}
+		}
+	}
+	slices.SortFunc(idle, func(a, b *archiveDir) int { return cmp.Compare(a.used, b.used) })
+	for _, d := range idle {
+		if open <= maxOpenArchives {
+			return
+		}
+		d.closeFiles()
+		open--
+	}
+}
+
+// openZip opens the zip entry named entry of the acquired directory d.
+func (d *archiveDir) openZip(entry string) (io.ReadCloser, error) {
+	f, ok := d.files[entry]
+	if !ok {
+		return nil, notFound(Join(d.archive, entry)) // Changed since its directory was read
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &dirEntry{Reader: rc, d: d, close: rc.Close}, nil
+}
+
+// openTar opens the tar entry named entry of the acquired directory d,
+// continuing from the idle stream nearest before it, or else from the start.
+func (d *archiveDir) openTar(entry string) (io.ReadCloser, error) {
+	pos := d.order[entry]
+	dirsMu.Lock()
+	best := -1
+	for i, s := range d.idle {
+		if s.next <= pos && (best < 0 || s.next > d.idle[best].next) {
+			best = i
+		}
+	}
+	var s *tarStream
+	if best >= 0 {
+		s = d.idle[best]
+		d.idle = slices.Delete(d.idle, best, best+1)
+	}
+	dirsMu.Unlock()
+
+	if s == nil {
+		var err error
+		if s, err = openTarStream(d.archive); err != nil {
+			return nil, err
+		}
+	}
+	for s.next <= pos {
+		if _, err := s.advance(); err != nil {
+			s.Close()
+			if err == io.EOF {
+				err = notFound(Join(d.archive, entry)) // Changed since its directory was read
+			}
+			return nil, err
+		}
+	}
+
+	// The stream is kept for the entries after this one
+	return &dirEntry{Reader: s.tr, d: d, close: func() error {
+		dirsMu.Lock()
+		defer dirsMu.Unlock()
+		if d.dropped || len(d.idle) >= maxTarStreams {
+			return s.Close()
+		}
+		d.idle = append(d.idle, s)
+		return nil
+	}}, nil
+}
+
+// dirEntry is an archive entry being read. Closing it releases its directory.
+type dirEntry struct {
+	io.Reader
+	d     *archiveDir
+	close func() error
+}
+
+func (e *dirEntry) Close() error {
+	err := e.close()
+	dirsMu.Lock()
+	e.d.release()
+	evict()
+	dirsMu.Unlock()
+	return err
+}
+
+// tarStream reads a possibly compressed tar archive from the start.
+type tarStream struct {
+	f  *os.File
+	gz *gzip.Reader
+	tr *tar.Reader
+	// next is the position of the next regular file with a safe name.
+	next int
+}
+
+// openTarStream opens archive for reading its entries in order.
+func openTarStream(archive string) (*tarStream, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	s := &tarStream{f: f}
+	var r io.Reader = f
+	lower := strings.ToLower(archive)
+	switch {
+	case strings.HasSuffix(lower, ".gz"), strings.HasSuffix(lower, ".tgz"):
+		if s.gz, err = gzip.NewReader(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		r = s.gz
+	case strings.HasSuffix(lower, ".bz2"), strings.HasSuffix(lower, ".tbz2"):
+		r = bzip2.NewReader(f)
+	}
+	s.tr = tar.NewReader(r)
+	return s, nil
+}
+
+// advance moves to the next regular file with a safe name, whose content
+// s.tr then reads. It returns io.EOF at the end of the archive.
+func (s *tarStream) advance() (*tar.Header, error) {
+	for {
+		hdr, err := s.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && safeName(hdr.Name) {
+			s.next++
+			return hdr, nil
+		}
+	}
+}
+
+func (s *tarStream) Close() error {
+	if s.gz != nil {
+		s.gz.Close()
+	}
+	return s.f.Close()
+}
+
+// walkTar calls fn for each regular file in a possibly compressed tar archive.
+func walkTar(archive string, fn func(*tar.Header, io.Reader) error) error {
+	s, err := openTarStream(archive)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	for {
+		hdr, err := s.advance()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(hdr, s.tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cacheDir returns the cache directory for archive. Its size and mtime are part
+// of the name, so a replaced archive gets a fresh directory.
+func cacheDir(archive string) (string, error) {
+	initDir()
+	if dirErr != nil {
+		return "", dirErr
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%d", archive, info.Size(), info.ModTime().UnixNano())))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])), nil
+}
+
+// claimDir creates the cache directory target of archive and records the
+// archive in it.
+func claimDir(target, archive string) error {
+	if _, err := os.Stat(filepath.Join(target, sourceFile)); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(target, sourceFile), []byte(archive), 0644)
+}
+
+// Prune removes the entries extracted from archives that were changed or
+// removed since, which are never used again. It returns how
+// many directories it removed and the bytes they held.
+func Prune() (dirs int, size int64, err error) {
+	initDir()
+	if dirErr != nil {
+		return 0, 0, dirErr
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		target := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || !stale(target) {
+			continue
+		}
+		n := dirSize(target)
+		mu := lock(target)
+		mu.Lock()
+		err := os.RemoveAll(target)
+		mu.Unlock()
+		if err != nil {
+			return dirs, size, err
+		}
+		dirs++
+		size += n
+	}
+	return dirs, size, nil
+}
+
+// stale reports whether the cache directory target no longer belongs to the
+// current version of its archive.
+func stale(target string) bool {
+	source, err := os.ReadFile(filepath.Join(target, sourceFile))
+	if err != nil {
+		info, err := os.Stat(target)
+		return err == nil && time.Since(info.ModTime()) > abandonedAge
+	}
+	current, err := cacheDir(string(source))
+	return err != nil || current != target
+}
+
+// dirSize returns the total size of the files below root.
+func dirSize(root string) int64 {
+	var size int64
+	filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// writeFile writes r to path through a temporary file, creating parent directories.
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// safeName reports whether an entry name stays inside the extraction directory.
+func safeName(name string) bool {
+	return name != "" && filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// notFound returns the error for a missing archive entry.
+func notFound(p string) error {
+	return &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+}
+
+// lock returns the mutex guarding the cache directory key.
+func lock(key string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	mu, ok := locks[key]
+	if !ok {
+		mu = &sync.Mutex{}
+		locks[key] = mu
+	}
+	return mu
+}
+
+// entryInfo describes an archive entry.
+type entryInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (e entryInfo) Name() string       { return e.name }
+func (e entryInfo) Size() int64        { return e.size }
+func (e entryInfo) Mode() fs.FileMode  { return 0444 }
+func (e entryInfo) ModTime() time.Time { return e.modTime }
+func (e entryInfo) IsDir() bool        { return false }
+func (e entryInfo) Sys() any           { return nil }
@@ -0,0 +1,289 @@
+package vfs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// packFiles are the entries of every test archive; the last one must be skipped.
+var packFiles = map[string]string{
+	"a.jpg":        "alpha",
+	"nested/b.png": "bravo",
+	"../evil.jpg":  "escape",
+}
+
+// writeZip creates a zip archive holding packFiles.
+func writeZip(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for name, content := range packFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// writeTarGz creates a gzipped tar archive holding packFiles.
+func writeTarGz(t *testing.T, path string) {
+	writeTarGzFiles(t, path, packFiles)
+}
+
+// writeTarGzFiles creates a gzipped tar archive holding files.
+func writeTarGzFiles(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+		io.WriteString(tw, content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+}
+
+// TestSplit verifies virtual paths are recognized only after an archive name.
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		path, archive, entry string
+		ok                   bool
+	}{
+		{"/w/pack.zip!/a/b.jpg", "/w/pack.zip", "a/b.jpg", true},
+		{"/w/odd!/name.tar.gz!/c.png", "/w/odd!/name.tar.gz", "c.png", true},
+		{"/w/plain!/d.jpg", "", "", false},
+		{"/w/e.jpg", "", "", false},
+	}
+
+	for _, tt := range tests {
+		// Act
+		archive, entry, ok := Split(tt.path)
+
+		// Assert
+		if archive != tt.archive || entry != tt.entry || ok != tt.ok {
+			t.Errorf("Split(%q) = %q, %q, %v", tt.path, archive, entry, ok)
+		}
+	}
+}
+
+// TestArchives verifies listing, reading, stat and extraction for both kinds of archive.
+func TestArchives(t *testing.T) {
+	// Arrange
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	tmpDir := t.TempDir()
+	archives := map[string]func(*testing.T, string){
+		filepath.Join(tmpDir, "pack.zip"):    writeZip,
+		filepath.Join(tmpDir, "pack.tar.gz"): writeTarGz,
+	}
+
+	for archive, write := range archives {
+		write(t, archive)
+
+		// Act
+		names, err := List(archive)
+		if err != nil {
+			t.Fatalf("%s: List failed: %v", archive, err)
+		}
+		slices.Sort(names)
+		rc, err := Open(Join(archive, "nested/b.png"))
+		if err != nil {
+			t.Fatalf("%s: Open failed: %v", archive, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		info, statErr := Stat(Join(archive, "a.jpg"))
+		extracted, extractErr := Extract(Join(archive, "a.jpg"))
+		_, missingErr := Open(Join(archive, "missing.jpg"))
+		_, evilErr := Open(Join(archive, "../evil.jpg"))
+
+		// Assert
+		if !slices.Equal(names, []string{"a.jpg", "nested/b.png"}) {
+			t.Errorf("%s: expected the two safe entries, got %v", archive, names)
+		}
+		if string(content) != "bravo" {
+			t.Errorf("%s: expected entry content, got %q", archive, content)
+		}
+		if statErr != nil || info.Size() != 5 || info.Name() != "a.jpg" {
+			t.Errorf("%s: unexpected Stat result %v, %v", archive, info, statErr)
+		}
+		if extractErr != nil {
+			t.Errorf("%s: Extract failed: %v", archive, extractErr)
+		} else if data, _ := os.ReadFile(extracted); string(data) != "alpha" {
+			t.Errorf("%s: expected extracted content, got %q", archive, data)
+		}
+		if !os.IsNotExist(missingErr) {
+			t.Errorf("%s: expected a not-exist error, got %v", archive, missingErr)
+		}
+		if evilErr == nil {
+			t.Errorf("%s: expected the escaping entry to be refused", archive)
+		}
+	}
+}
+
+// TestTarStreaming verifies that tar entries are read without unpacking the
+// archive, and that reading them in order reads through the archive once.
+func TestTarStreaming(t *testing.T) {
+	// Arrange
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dirOnce = sync.Once{}
+	archive := filepath.Join(t.TempDir(), "many.tar.gz")
+	files := make(map[string]string)
+	for i := range 20 {
+		files[fmt.Sprintf("%02d.jpg", i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTarGzFiles(t, archive, files)
+	names, _ := List(archive)
+
+	// Act
+	var readErr error
+	for _, name := range names {
+		if _, err := Stat(Join(archive, name)); err != nil {
+			readErr = err
+		}
+		rc, err := Open(Join(archive, name))
+		if err != nil {
+			readErr = err
+			continue
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(content) != files[name] {
+			t.Errorf("Expected %q in %s, got %q", files[name], name, content)
+		}
+	}
+	dirsMu.Lock()
+	d := archiveDirs[archive]
+	streams, next := len(d.idle), d.idle[0].next
+	dirsMu.Unlock()
+	first, firstErr := Open(Join(archive, names[0]))
+	if firstErr == nil {
+		first.Close()
+	}
+	cacheDir, _ := os.UserCacheDir()
+	unpacked, _ := os.ReadDir(filepath.Join(cacheDir, "waller", "archives"))
+
+	// Assert
+	if readErr != nil || firstErr != nil {
+		t.Fatalf("Expected no error, got %v, %v", readErr, firstErr)
+	}
+	if streams != 1 || next != len(names) {
+		t.Errorf("Expected one stream through all %d entries, got %d at %d", len(names), streams, next)
+	}
+	if len(unpacked) != 0 {
+		t.Errorf("Expected nothing unpacked, got %v", unpacked)
+	}
+}
+
+// TestOpenArchivesBounded verifies that zip archives stay open for their next
+// entries, but only the most recently used ones.
+func TestOpenArchivesBounded(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	var archives []string
+	for i := range maxOpenArchives + 2 {
+		archive := filepath.Join(tmpDir, fmt.Sprintf("pack%d.zip", i))
+		writeZip(t, archive)
+		archives = append(archives, archive)
+	}
+
+	// Act
+	read := func(archive string) *zip.ReadCloser {
+		rc, err := Open(Join(archive, "a.jpg"))
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		rc.Close()
+		dirsMu.Lock()
+		defer dirsMu.Unlock()
+		return archiveDirs[archive].zr
+	}
+	first, again := read(archives[0]), read(archives[0])
+	for _, archive := range archives[1:] {
+		read(archive)
+	}
+	dirsMu.Lock()
+	open := 0
+	for _, archive := range archives {
+		if archiveDirs[archive].open() {
+			open++
+		}
+	}
+	evicted := archiveDirs[archives[0]].zr == nil
+	dirsMu.Unlock()
+	_, statErr := Stat(Join(archives[0], "a.jpg"))
+
+	// Assert
+	if first == nil || first != again {
+		t.Errorf("Expected the second entry to be read from the open archive")
+	}
+	if open != maxOpenArchives || !evicted {
+		t.Errorf("Expected %d archives open without the oldest, got %d, oldest evicted %v", maxOpenArchives, open, evicted)
+	}
+	if statErr != nil {
+		t.Errorf("Expected the closed archive's entries to be known, got %v", statErr)
+	}
+}
+
+// TestPrune verifies that the extracted entries of replaced and removed archives
+// are removed and those of unchanged archives kept.
+func TestPrune(t *testing.T) {
+	// Arrange
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dirOnce = sync.Once{}
+	tmpDir := t.TempDir()
+	kept := filepath.Join(tmpDir, "kept.tar.gz")
+	replaced := filepath.Join(tmpDir, "replaced.tar.gz")
+	removed := filepath.Join(tmpDir, "removed.zip")
+	writeTarGz(t, kept)
+	writeTarGz(t, replaced)
+	writeZip(t, removed)
+	for _, archive := range []string{kept, replaced, removed} {
+		if _, err := Extract(Join(archive, "a.jpg")); err != nil {
+			t.Fatalf("Setup failed: %v", err)
+		}
+	}
+	os.Chtimes(replaced, time.Now(), time.Now().Add(time.Hour))
+	os.Remove(removed)
+
+	// Act
+	dirs, size, err := Prune()
+	entries, _ := os.ReadDir(dir)
+	keptDir, _ := cacheDir(kept)
+
+	// Assert
+	if err != nil || dirs != 2 || size == 0 {
+		t.Errorf("Expected 2 directories to be removed, got %d (%d bytes), %v", dirs, size, err)
+	}
+	if len(entries) != 1 || filepath.Join(dir, entries[0].Name()) != keptDir {
+		t.Errorf("Expected only the unchanged archive to be kept, got %v", entries)
+	}
+}
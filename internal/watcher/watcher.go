@@ -0,0 +1,254 @@
+// Package watcher reports file changes below wallpaper directories using inotify.
+// Events are debounced so that bulk copies arrive as a single batch.
+package watcher
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// watchMask selects the inotify events that can change the wallpaper list.
+// IN_CLOSE_WRITE is used instead of IN_MODIFY so a file is reported once it is fully written.
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// dirID identifies a directory by device and inode, so that symlink loops and
+// directories reachable through several links are only watched once.
+type dirID struct {
+	dev uint64
+	ino uint64
+}
+
+// Watcher watches directory trees and delivers batches of changed paths.
+type Watcher struct {
+	fd             int
+	file           *os.File
+	dirs           []string
+	followSymlinks bool
+
+	mu      sync.Mutex
+	watches map[int32]string // watch descriptor → directory
+
+	raw     chan string
+	changes chan []string
+	done    chan struct{}
+	once    sync.Once
+}
+
+// New starts watching dirs and all their subdirectories, including symlinked
+// ones if followSymlinks is set, as in a scan with backend.Options.FollowSymlinks.
+// Changed paths are collected until no event has arrived for delay,
+// then delivered as one batch on Changes.
+func New(dirs []string, followSymlinks bool, delay time.Duration) (*Watcher, error) {
+	// A non-blocking fd lets os.File use the runtime poller, so Close unblocks Read.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		fd:             fd,
+		file:           os.NewFile(uintptr(fd), "inotify"),
+		dirs:           dirs,
+		followSymlinks: followSymlinks,
+		watches:        make(map[int32]string),
+		raw:            make(chan string, 256),
+		changes:        make(chan []string, 1),
+		done:           make(chan struct{}),
+	}
+
+	// Directories shared between several roots are only walked once
+	visited := make(map[dirID]bool)
+	for _, dir := range dirs {
+		w.addTree(dir, visited)
+	}
+
+	go w.readEvents()
+	go w.debounce(delay)
+
+	return w, nil
+}
+
+// Changes returns the channel on which debounced batches of changed paths arrive.
+// A batch containing a watched root means the whole tree should be rescanned.
+// The channel is closed after Close.
+func (w *Watcher) Changes() <-chan []string {
+	return w.changes
+}
+
+// Close stops watching and releases the inotify instance.
+func (w *Watcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// addTree adds a watch for root and every directory below it, skipping the
+// directories in visited. Symlinked directories are followed only if
+// followSymlinks is set. It returns false once no more watches can be added.
+func (w *Watcher) addTree(root string, visited map[dirID]bool) bool {
+	info, err := os.Stat(root)
+	if err != nil || !info.IsDir() {
+		return true // Unreadable entries are skipped, like in discovery
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		id := dirID{dev: uint64(st.Dev), ino: st.Ino}
+		if visited[id] {
+			return true
+		}
+		visited[id] = true
+	}
+
+	wd, err := syscall.InotifyAddWatch(w.fd, root, watchMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			slog.Warn("inotify watch limit reached, raise fs.inotify.max_user_watches", "dir", root)
+			return false
+		}
+		slog.Warn("Failed to watch directory", "dir", root, "error", err)
+		return true
+	}
+
+	w.mu.Lock()
+	w.watches[int32(wd)] = root
+	w.mu.Unlock()
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return true
+	}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 && w.followSymlinks {
+			target, err := os.Stat(path)
+			isDir = err == nil && target.IsDir()
+		}
+		if isDir && !w.addTree(path, visited) {
+			return false
+		}
+	}
+	return true
+}
+
+// readEvents parses inotify events and forwards the affected paths.
+func (w *Watcher) readEvents() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return // Closed
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.handleEvent(event.Wd, event.Mask, trimName(nameBytes))
+		}
+	}
+}
+
+// handleEvent updates the watch set for a single event and reports its path.
+func (w *Watcher) handleEvent(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost; ask for a full rescan of every root
+		for _, dir := range w.dirs {
+			w.emit(dir)
+		}
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.watches[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	path := dir
+	if name != "" {
+		path = filepath.Join(dir, name)
+	}
+
+	// New directories (created or moved in) need their own watches, and so
+	// do links to directories when those are followed
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		isDir := mask&syscall.IN_ISDIR != 0
+		if info, err := os.Lstat(path); !isDir && w.followSymlinks && err == nil && info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			isDir = err == nil && target.IsDir()
+		}
+		if isDir {
+			w.addTree(path, make(map[dirID]bool))
+		}
+	}
+
+	w.emit(path)
+}
+
+// emit queues a changed path for the debouncer.
+func (w *Watcher) emit(path string) {
+	select {
+	case w.raw <- path:
+	case <-w.done:
+	}
+}
+
+// debounce collects paths until the tree has been quiet for delay, then delivers them.
+func (w *Watcher) debounce(delay time.Duration) {
+	defer close(w.changes)
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(delay)
+	timer.Stop()
+
+	for {
+		select {
+		case path := <-w.raw:
+			pending[path] = true
+			timer.Reset(delay)
+
+		case <-timer.C:
+			batch := make([]string, 0, len(pending))
+			for path := range pending {
+				batch = append(batch, path)
+			}
+			clear(pending)
+
+			select {
+			case w.changes <- batch:
+			case <-w.done:
+				return
+			}
+
+		case <-w.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// trimName converts the NUL-padded name of an inotify event to a string.
+func trimName(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
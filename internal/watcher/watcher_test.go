@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// waitBatch waits for the next batch of changes or fails the test.
+func waitBatch(t *testing.T, w *Watcher) []string {
+	t.Helper()
+	select {
+	case batch := <-w.Changes():
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for changes")
+		return nil
+	}
+}
+
+// TestWatcherDebouncesBulkChanges verifies that many files arrive as one batch.
+func TestWatcherDebouncesBulkChanges(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	w, err := New([]string{tmpDir}, false, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer w.Close()
+
+	// Act: simulate a bulk copy
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte("fake content"), 0644)
+	}
+	batch := waitBatch(t, w)
+
+	// Assert
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		if !slices.Contains(batch, filepath.Join(tmpDir, name)) {
+			t.Errorf("Expected %s in batch, got %v", name, batch)
+		}
+	}
+}
+
+// TestWatcherNewSubdirectory verifies that directories created later are watched too.
+func TestWatcherNewSubdirectory(t *testing.T) {
+	// Arrange
+	tmpDir := t.TempDir()
+	w, err := New([]string{tmpDir}, false, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer w.Close()
+
+	sub := filepath.Join(tmpDir, "nature")
+	os.Mkdir(sub, 0755)
+	waitBatch(t, w)
+
+	// Act
+	target := filepath.Join(sub, "forest.png")
+	os.WriteFile(target, []byte("fake content"), 0644)
+	batch := waitBatch(t, w)
+
+	// Assert
+	if !slices.Contains(batch, target) {
+		t.Errorf("Expected %s in batch, got %v", target, batch)
+	}
+}
+
+// TestWatcherFollowsSymlinks verifies that symlinked directories are watched
+// when symlinks are followed, and that a link loop does not hang the watcher.
+func TestWatcherFollowsSymlinks(t *testing.T) {
+	// Arrange
+	root, elsewhere := t.TempDir(), t.TempDir()
+	os.Symlink(elsewhere, filepath.Join(root, "linked"))
+	os.Symlink(root, filepath.Join(elsewhere, "loop"))
+	w, err := New([]string{root}, true, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer w.Close()
+
+	// Act
+	os.WriteFile(filepath.Join(elsewhere, "forest.png"), []byte("fake content"), 0644)
+	batch := waitBatch(t, w)
+
+	// Assert
+	if target := filepath.Join(root, "linked", "forest.png"); !slices.Contains(batch, target) {
+		t.Errorf("Expected %s in batch, got %v", target, batch)
+	}
+}
@@ -6,53 +6,145 @@ import (
 	"log/slog"
 	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"waller/internal/backend"
+	"waller/internal/cache"
 	"waller/internal/config"
 	"waller/internal/gui"
 	"waller/internal/layer"
 	"waller/internal/manager"
+	"waller/internal/metadata"
+	"waller/internal/order"
+	"waller/internal/palette"
+	"waller/internal/remote"
+	"waller/internal/watcher"
 
 	"github.com/gotk3/gotk3/gtk"
 )
 
+// watchDebounce is how long the libraries must be quiet before a rescan.
+const watchDebounce = time.Second
+
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "duplicates":
+			runDuplicates(os.Args[2:])
+			return
+		case "cache":
+			runCache(os.Args[2:])
+			return
+		case "thumbnails":
+			runThumbnails(os.Args[2:])
+			return
+		}
+	}
+
 	// Parse CLI flags
 	daemonFlag := flag.String("daemon", "", "Start wallpaper daemon with image path")
 	monitorIdxFlag := flag.Int("monitor-index", -1, "Monitor index to display on")
 	autoInterval := flag.Int("auto", 0, "Interval in seconds to rotate wallpapers automatically")
 	randomFlag := flag.Bool("random", false, "Apply a random wallpaper once")
+	libraryFlag := flag.String("library", "", "Only use wallpapers from the named library")
+	reportFlag := flag.Bool("report", false, "Scan the libraries and print skipped files with reasons")
+	matchFlag := flag.Bool("match", false, "Only pick wallpapers whose aspect ratio and resolution suit the monitor")
+	colorFlag := flag.String("color", "", "Only pick wallpapers dominated by a color (red, orange, yellow, green, cyan, blue, purple, pink, white, gray, black)")
+	favoritesFlag := flag.Bool("favorites", false, "Only pick wallpapers marked as favorite")
+	tagFlag := flag.String("tag", "", "Only pick wallpapers with at least one of these comma-separated tags")
+	setFlag := flag.String("set", "", "Apply this image file or http(s) URL")
+	sortFlag := flag.String("sort", "", "With --auto, rotate through wallpapers in this order instead of at random: name, mtime, size, resolution, hue or random, optionally followed by :desc")
 
 	flag.Parse()
 
+	if *colorFlag != "" && !palette.ValidName(*colorFlag) {
+		fmt.Printf("Unknown color %q, expected one of: %s\n", *colorFlag, strings.Join(palette.Hues, ", "))
+		os.Exit(2)
+	}
+
+	var sequence order.Order
+	if *sortFlag != "" {
+		o, err := order.Parse(*sortFlag)
+		if err != nil {
+			fmt.Printf("Invalid --sort: %v\n", err)
+			os.Exit(2)
+		}
+		sequence = o
+	}
+
+	filters := pickFilters{
+		match:        *matchFlag,
+		monitorIndex: *monitorIdxFlag,
+		color:        *colorFlag,
+		favorites:    *favoritesFlag,
+		tags:         metadata.ParseTags(*tagFlag),
+	}
+
 	// Daemon Mode (Wallpaper Window, CGO)
 	if *daemonFlag != "" {
 		layer.RunDaemon(*daemonFlag, *monitorIdxFlag)
 		return
 	}
 
+	// Scan Report Mode
+	if *reportFlag {
+		printScanReport(*libraryFlag)
+		return
+	}
+
+	// Set Mode (one-time)
+	if *setFlag != "" {
+		path := *setFlag
+		// The daemon runs in another working directory, so relative paths are resolved here
+		if !remote.IsURL(path) {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				fmt.Printf("Invalid path %q: %v\n", path, err)
+				os.Exit(1)
+			}
+			path = abs
+		}
+		if err := manager.ApplyWallpaper(path, *monitorIdxFlag); err != nil {
+			fmt.Printf("Failed to apply wallpaper: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Applied wallpaper: %s\n", path)
+		return
+	}
+
 	// Random Wallpaper Mode (one-time)
 	if *randomFlag {
-		files, _ := loadConfigAndGetWallpapers()
+		_, files, _ := loadConfigAndGetWallpapers(*libraryFlag)
+		filters.readMonitors()
+		files, err := filters.apply(files)
+		if err != nil {
+			slog.Error("Could not filter wallpapers", "error", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			slog.Error("No wallpapers match the filters")
+			os.Exit(1)
+		}
 		ri := rand.IntN(len(files))
 		selected := files[ri]
 
-		manager.ApplyWallpaper(selected, *monitorIdxFlag)
+		if err := manager.ApplyWallpaper(selected, *monitorIdxFlag); err != nil {
+			fmt.Printf("Failed to apply wallpaper: %v\n", err)
+			os.Exit(1)
+		}
 		fmt.Printf("Applied random wallpaper: %s\n", selected)
 		return
 	}
 
 	if *autoInterval > 0 {
-		files, wallpaperDir := loadConfigAndGetWallpapers()
-		fmt.Printf("Starting auto-rotation: dir=%s interval=%ds wallpapers=%d\n", wallpaperDir, *autoInterval, len(files))
-
-		for {
-			ri := rand.IntN(len(files))
-			selected := files[ri]
-			manager.ApplyWallpaper(selected, -1)
-			time.Sleep(time.Duration(*autoInterval) * time.Second)
-		}
+		// Rotation applies to all monitors, so matching must suit every one
+		filters.monitorIndex = -1
+		runAutoRotation(*libraryFlag, *autoInterval, filters, sequence)
 	}
 
 	if err := gui.Run(); err != nil {
@@ -61,31 +153,152 @@ func main() {
 	}
 }
 
-func loadConfigAndGetWallpapers() ([]string, string) {
+// runAutoRotation applies a random wallpaper every interval seconds, forever.
+// The rotation pool follows files added to or removed from the libraries.
+// Only wallpapers passing filters are picked. If sequence has a key, the pool
+// is sorted by it and shown in turn instead of at random.
+func runAutoRotation(library string, interval int, filters pickFilters, sequence order.Order) {
+	cfg, files, dirs := loadConfigAndGetWallpapers(library)
+	filters.readMonitors()
+
+	pool := func(files []string) ([]string, error) {
+		files, err := filters.apply(files)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			slog.Warn("No wallpapers match the filters")
+		}
+		if sequence.Key != "" {
+			if err := sortWallpapers(files, sequence); err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+	files, err := pool(files)
+	if err != nil {
+		slog.Error("Could not filter wallpapers", "error", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Starting auto-rotation: dirs=%s interval=%ds wallpapers=%d\n", strings.Join(dirs, ","), interval, len(files))
+
+	// next is the position of the following wallpaper in a sequential rotation
+	var poolMu sync.Mutex
+	next, last := 0, ""
+	if w, err := watcher.New(dirs, cfg.FollowSymlinks, watchDebounce); err != nil {
+		slog.Warn("Live directory watching unavailable", "error", err)
+	} else {
+		go func() {
+			for range w.Changes() {
+				updated, err := backend.GetWallpapers(dirs, backend.OptionsFromConfig(cfg))
+				if err != nil {
+					slog.Warn("Rescan failed", "error", err)
+					continue
+				}
+				// A failed update keeps rotating through the previous pool
+				if updated, err = pool(updated); err != nil {
+					slog.Warn("Could not filter rescanned wallpapers", "error", err)
+					continue
+				}
+				poolMu.Lock()
+				files = updated
+				// Carry on after the wallpaper shown last, wherever it moved to
+				next = slices.Index(files, last) + 1
+				poolMu.Unlock()
+				slog.Info("Wallpaper pool updated", "wallpapers", len(updated))
+			}
+		}()
+	}
+
+	for {
+		poolMu.Lock()
+		selected := ""
+		if len(files) > 0 {
+			if sequence.Key == "" {
+				selected = files[rand.IntN(len(files))]
+			} else {
+				selected = files[next%len(files)]
+				next = (next + 1) % len(files)
+			}
+			last = selected
+		}
+		poolMu.Unlock()
+
+		if selected != "" {
+			if err := manager.ApplyWallpaper(selected, -1); err != nil {
+				slog.Warn("Failed to apply wallpaper", "path", selected, "error", err)
+			}
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+	}
+}
+
+// loadConfigAndGetWallpapers scans the enabled libraries, or only the named
+// library if one is given, and returns the config, the wallpapers and the scanned dirs.
+func loadConfigAndGetWallpapers(library string) (*config.Config, []string, []string) {
 	if err := gtk.InitCheck(nil); err != nil {
 		slog.Error("GTK init failed", "error", err)
 		os.Exit(1)
 	}
 
+	cfg, dirs := loadConfigAndDirs(library)
+
+	files, err := backend.GetWallpapers(dirs, backend.OptionsFromConfig(cfg))
+	if err != nil {
+		slog.Error("Error scanning wallpapers", "error", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		slog.Error("No wallpapers found in directory")
+		os.Exit(1)
+	}
+
+	return cfg, files, dirs
+}
+
+// loadConfigAndDirs loads the config and resolves the library directories to scan.
+func loadConfigAndDirs(library string) (*config.Config, []string) {
 	cfg, err := config.Load()
 	if err != nil {
 		slog.Error("Could not load config", "error", err)
 		os.Exit(1)
 	}
-	if cfg.WallpaperDir == "" {
-		slog.Error("No wallpaper directory configured, please run GUI first")
+	cache.ShareThumbnails(cfg.ShareThumbnails)
+	cache.UseLayout(cache.ParseLayout(cfg.ThumbnailStore))
+
+	dirs := cfg.EnabledDirs()
+	if library != "" {
+		lib := cfg.FindLibrary(library)
+		if lib == nil {
+			slog.Error("Unknown library", "name", library)
+			os.Exit(1)
+		}
+		dirs = []string{lib.Path}
+	}
+	if len(dirs) == 0 {
+		slog.Error("No wallpaper library configured, please run GUI first")
 		os.Exit(1)
 	}
 
-	files, err := backend.GetWallpapers(cfg.WallpaperDir)
+	return cfg, dirs
+}
+
+// printScanReport scans with content verification and lists every skipped file.
+func printScanReport(library string) {
+	cfg, dirs := loadConfigAndDirs(library)
+
+	opts := backend.OptionsFromConfig(cfg)
+	opts.Verify = true
+	result, err := backend.Scan(dirs, opts)
 	if err != nil {
 		slog.Error("Error scanning wallpapers", "error", err)
 		os.Exit(1)
 	}
-	if len(files) == 0 {
-		slog.Error("No wallpapers found in directory")
-		os.Exit(1)
-	}
 
-	return files, cfg.WallpaperDir
+	fmt.Printf("Scanned %d libraries: %d wallpapers, %d skipped\n", len(dirs), len(result.Wallpapers), len(result.Skipped))
+	for _, sk := range result.Skipped {
+		fmt.Printf("  %s: %s\n", sk.Path, sk.Reason)
+	}
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"waller/internal/backend"
+	"waller/internal/cache"
+	"waller/internal/index"
+	"waller/internal/pixbuf"
+	"waller/internal/prewarm"
+	"waller/internal/priority"
+)
+
+// thumbnailsUsage lists the actions of "waller thumbnails".
+const thumbnailsUsage = `Usage: waller thumbnails build [flags]
+
+Generates the thumbnails, colors and image metadata of every wallpaper in the
+enabled libraries, so the GUI shows them without waiting. Run with --help
+after "build" for the flags.`
+
+// runThumbnails implements "waller thumbnails": it pre-warms the thumbnail cache.
+func runThumbnails(args []string) {
+	if len(args) == 0 || args[0] != "build" {
+		fmt.Println(thumbnailsUsage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("thumbnails build", flag.ExitOnError)
+	library := fs.String("library", "", "Only build thumbnails for the named library")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Number of images processed at once")
+	nice := fs.Int("nice", 0, "Run at this CPU niceness, from 0 to 19 (lowest priority)")
+	idleIO := fs.Bool("idle-io", false, "Only use the disk while no other program does")
+	scale := fs.Int("scale", 1, "Display scale factor the thumbnails are built for")
+	allSizes := fs.Bool("all-sizes", false, "Build every configured zoom level instead of the current one")
+	quiet := fs.Bool("quiet", false, "Do not show progress")
+	fs.Parse(args[1:])
+
+	if *jobs < 1 || *scale < 1 {
+		fmt.Println("--jobs and --scale must be at least 1")
+		os.Exit(2)
+	}
+
+	// Lower the priority before any work, so scanning is throttled as well
+	if *nice > 0 {
+		if err := priority.Nice(*nice); err != nil {
+			slog.Error("Could not set CPU priority", "error", err)
+			os.Exit(1)
+		}
+	}
+	if *idleIO {
+		if err := priority.IdleIO(); err != nil {
+			slog.Error("Could not set I/O priority", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	cfg, dirs := loadConfigAndDirs(*library)
+	// Large photos are decoded at reduced size, as in the GUI
+	cache.SetScaledDecoder(pixbuf.DecodeScaled)
+	files, err := backend.GetWallpapers(dirs, backend.OptionsFromConfig(cfg))
+	if err != nil {
+		slog.Error("Error scanning wallpapers", "error", err)
+		os.Exit(1)
+	}
+
+	// The grid asks for thumbnails of its zoom level at the display's scale
+	sizes := []int{cfg.Zoom()}
+	if *allSizes {
+		sizes = cfg.ZoomLevels()
+	}
+	widths := make([]int, len(sizes))
+	for i, size := range sizes {
+		widths[i] = size * *scale
+	}
+
+	idx, err := index.Open()
+	if err != nil {
+		slog.Warn("Failed to open metadata index", "error", err)
+	}
+
+	// A systemd timer or logout stops the build with SIGTERM; what is done is kept
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	p := newProgress(len(files), !*quiet)
+	b := prewarm.Builder{Index: idx, Widths: widths, Done: p.add}
+	b.Run(ctx, files, *jobs)
+	p.finish()
+
+	done, failed := p.done.Load(), p.failed.Load()
+	fmt.Printf("Processed %d of %d wallpapers in %s", done, len(files), time.Since(start).Round(time.Second))
+	if failed > 0 {
+		fmt.Printf(", %d could not be read", failed)
+	}
+	fmt.Println()
+	if ctx.Err() != nil {
+		fmt.Println("Interrupted; run again to finish")
+		os.Exit(1)
+	}
+}
+
+// progress counts processed wallpapers and shows the count on the terminal.
+type progress struct {
+	total        int
+	done, failed atomic.Int64
+	start        time.Time
+	// interactive redraws one line; otherwise a line is printed now and then,
+	// which suits logs such as the systemd journal
+	interactive bool
+	show        bool
+	stop        chan struct{}
+	stopped     chan struct{}
+}
+
+// progressInterval and progressLogInterval are how often the progress is
+// shown on a terminal and in a log.
+const (
+	progressInterval    = 200 * time.Millisecond
+	progressLogInterval = 30 * time.Second
+)
+
+// newProgress starts showing the progress of total wallpapers if show is set.
+func newProgress(total int, show bool) *progress {
+	p := &progress{total: total, start: time.Now(), show: show, stop: make(chan struct{}), stopped: make(chan struct{})}
+	if info, err := os.Stdout.Stat(); err == nil {
+		p.interactive = info.Mode()&os.ModeCharDevice != 0
+	}
+	go p.loop()
+	return p
+}
+
+// add records a processed wallpaper.
+func (p *progress) add(ok bool) {
+	p.done.Add(1)
+	if !ok {
+		p.failed.Add(1)
+	}
+}
+
+// loop shows the progress until finish is called.
+func (p *progress) loop() {
+	defer close(p.stopped)
+	if !p.show {
+		<-p.stop
+		return
+	}
+	interval := progressLogInterval
+	if p.interactive {
+		interval = progressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.print()
+		case <-p.stop:
+			if p.interactive {
+				p.print()
+				fmt.Println()
+			}
+			return
+		}
+	}
+}
+
+// print shows the count, rate and estimated time left.
+func (p *progress) print() {
+	done := int(p.done.Load())
+	line := fmt.Sprintf("%d/%d wallpapers", done, p.total)
+	if p.total > 0 {
+		line = fmt.Sprintf("%s (%d%%)", line, done*100/p.total)
+	}
+	if elapsed := time.Since(p.start); done > 0 && done < p.total {
+		rate := float64(done) / elapsed.Seconds()
+		left := time.Duration(float64(p.total-done) / rate * float64(time.Second))
+		line = fmt.Sprintf("%s, %.1f/s, %s left", line, rate, left.Round(time.Second))
+	}
+	if p.interactive {
+		fmt.Printf("\r\033[K%s", line)
+	} else {
+		fmt.Println(line)
+	}
+}
+
+// finish stops showing the progress.
+func (p *progress) finish() {
+	close(p.stop)
+	<-p.stopped
+}